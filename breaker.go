@@ -0,0 +1,116 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Get when a local load is needed but
+// the group's circuit breaker is open after repeated load failures.
+// See Group.SetCircuitBreaker.
+var ErrCircuitOpen = errors.New("groupcache: circuit open after repeated load failures")
+
+// circuitBreaker stops local loads after repeated failures. Its zero
+// value never opens.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int           // consecutive failures that open the circuit; 0 disables
+	window    time.Duration // the failures must happen within window
+	cooldown  time.Duration // how long the circuit stays open
+
+	failures  int       // consecutive failures so far
+	first     time.Time // time of the first of those failures
+	openUntil time.Time // zero while the circuit is closed
+	probing   bool      // a half-open probe is in flight
+}
+
+func (cb *circuitBreaker) set(threshold int, window, cooldown time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.threshold = threshold
+	cb.window = window
+	cb.cooldown = cooldown
+	cb.failures = 0
+	cb.openUntil = time.Time{}
+	cb.probing = false
+}
+
+// allow returns ErrCircuitOpen if a local load must not be attempted
+// at now. Once the cooldown has passed, a single probe load is let
+// through, for which allow reports probe. The probe's result, passed
+// to record, closes or reopens the circuit; the caller must call
+// endProbe when the probe is over, even if it panicked, so that
+// another probe can follow if record was not reached.
+func (cb *circuitBreaker) allow(now time.Time) (probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.threshold <= 0 || cb.openUntil.IsZero() {
+		return false, nil
+	}
+	if now.Before(cb.openUntil) || cb.probing {
+		return false, ErrCircuitOpen
+	}
+	cb.probing = true
+	return true, nil
+}
+
+// endProbe ends the probe that allow let through.
+func (cb *circuitBreaker) endProbe() {
+	cb.mu.Lock()
+	cb.probing = false
+	cb.mu.Unlock()
+}
+
+// record notes the result, at now, of a local load that allow let
+// through, and whether that load was the probe. While the circuit is
+// open, only the probe's result counts; loads that were let through
+// before it opened no longer decide anything.
+func (cb *circuitBreaker) record(now time.Time, probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.threshold <= 0 {
+		return
+	}
+	if !cb.openUntil.IsZero() {
+		if !probe {
+			return
+		}
+		if err == nil {
+			cb.failures = 0
+			cb.openUntil = time.Time{}
+		} else {
+			cb.openUntil = now.Add(cb.cooldown)
+		}
+		return
+	}
+	if err == nil {
+		cb.failures = 0
+		return
+	}
+	if cb.failures == 0 || now.Sub(cb.first) > cb.window {
+		cb.failures = 0
+		cb.first = now
+	}
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.failures = 0
+		cb.openUntil = now.Add(cb.cooldown)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"io"
 	"strings"
+	"time"
 )
 
 // A ByteView holds an immutable view of bytes.
@@ -33,6 +34,23 @@ type ByteView struct {
 	// If b is non-nil, b is used, else s is used.
 	b []byte //如果b非空则使用b,反之使用s
 	s string
+
+	// e is the time after which the cached value expires.
+	// The zero value means it never expires.
+	e time.Time
+
+	// gen is the generation reported by the owning peer for a value
+	// held in the hotCache; see Group.GenerationFunc.
+	gen uint64
+
+	// m is when the value was added to the cache, or the zero value
+	// for a value that was never cached.
+	m time.Time
+}
+
+// expired reports whether v has an expiry time that is before now.
+func (v ByteView) expired(now time.Time) bool {
+	return !v.e.IsZero() && now.After(v.e)
 }
 
 // Len returns the view's length.
@@ -43,6 +61,28 @@ func (v ByteView) Len() int { //返回一个view的长度
 	return len(v.s)
 }
 
+// IsEmpty reports whether the view holds no bytes. An empty value is
+// still a value: a Getter that sets one caches it like any other, and
+// later Gets of the key are cache hits.
+func (v ByteView) IsEmpty() bool {
+	return v.Len() == 0
+}
+
+// BytesNoCopy returns the view's underlying byte slice without
+// copying it, for read-only use in hot paths.
+//
+// The returned slice may be shared with the cache and with every
+// other caller holding the same value. It MUST NOT be modified, not
+// even temporarily; doing so corrupts the cached value for everyone.
+// Views backed by a string have no byte slice to share, so for those
+// a copy is made.
+func (v ByteView) BytesNoCopy() []byte {
+	if v.b != nil {
+		return v.b
+	}
+	return []byte(v.s)
+}
+
 // ByteSlice returns a copy of the data as a byte slice.
 func (v ByteView) ByteSlice() []byte { //获取一份[]byte类型的view值的拷贝
 	if v.b != nil {
@@ -51,6 +91,17 @@ func (v ByteView) ByteSlice() []byte { //获取一份[]byte类型的view值的
 	return []byte(v.s)
 }
 
+// Clone returns a ByteView that does not share its backing array with
+// v, so it may be retained after the cache entry v came from has been
+// evicted. String-backed views are returned as is, since strings are
+// immutable.
+func (v ByteView) Clone() ByteView {
+	if v.b != nil {
+		v.b = cloneBytes(v.b)
+	}
+	return v
+}
+
 // String returns the data as a string, making a copy if necessary.
 func (v ByteView) String() string { //上一个是返回[]byte类型，这里是string类型
 	if v.b != nil {
@@ -70,17 +121,43 @@ func (v ByteView) At(i int) byte { //返回第i个byte
 // Slice slices the view between the provided from and to indices.
 func (v ByteView) Slice(from, to int) ByteView { //返回从索引from到to的view的切分结果
 	if v.b != nil {
-		return ByteView{b: v.b[from:to]}
+		v.b = v.b[from:to]
+	} else {
+		v.s = v.s[from:to]
 	}
-	return ByteView{s: v.s[from:to]}
+	return v
 }
 
 // SliceFrom slices the view from the provided index until the end.
 func (v ByteView) SliceFrom(from int) ByteView { //相当于上面的to为len(b)
 	if v.b != nil {
-		return ByteView{b: v.b[from:]}
+		v.b = v.b[from:]
+	} else {
+		v.s = v.s[from:]
+	}
+	return v
+}
+
+// Lines calls fn for each newline-terminated line in v, without the
+// newline, until fn returns false. The final line need not end in a
+// newline. The lines share v's memory, so no bytes are copied.
+func (v ByteView) Lines(fn func(line ByteView) bool) {
+	for v.Len() > 0 {
+		var i int
+		if v.b != nil {
+			i = bytes.IndexByte(v.b, '\n')
+		} else {
+			i = strings.IndexByte(v.s, '\n')
+		}
+		if i < 0 {
+			fn(v)
+			return
+		}
+		if !fn(v.Slice(0, i)) {
+			return
+		}
+		v = v.SliceFrom(i + 1)
 	}
-	return ByteView{s: v.s[from:]}
 }
 
 // Copy copies b into dest and returns the number of bytes copied.
@@ -175,3 +252,12 @@ func (v ByteView) WriteTo(w io.Writer) (n int64, err error) {
 	n = int64(m)
 	return
 }
+
+// AppendTo appends the bytes in v to dst and returns the extended
+// slice.
+func (v ByteView) AppendTo(dst []byte) []byte {
+	if v.b != nil {
+		return append(dst, v.b...)
+	}
+	return append(dst, v.s...)
+}
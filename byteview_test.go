@@ -22,6 +22,7 @@ import (
 	"io"
 	"io/ioutil"
 	"testing"
+	"time"
 )
 
 func TestByteView(t *testing.T) {
@@ -139,6 +140,85 @@ func TestByteViewSlice(t *testing.T) {
 	}
 }
 
+func TestByteViewClone(t *testing.T) {
+	b := []byte("abc")
+	v := of(b)
+	c := v.Clone()
+	b[0] = 'x'
+	if got := c.String(); got != "abc" {
+		t.Errorf("Clone after mutating source = %q; want %q", got, "abc")
+	}
+	if s := of("abc").Clone(); s.String() != "abc" {
+		t.Errorf("Clone of string view = %q; want %q", s.String(), "abc")
+	}
+}
+
+func TestByteViewKeepsMetadata(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	for _, v := range []ByteView{{b: []byte("abcd")}, {s: "abcd"}} {
+		v.e, v.gen, v.m = now.Add(time.Minute), 7, now
+		for name, got := range map[string]ByteView{
+			"Clone":     v.Clone(),
+			"Slice":     v.Slice(1, 3),
+			"SliceFrom": v.SliceFrom(1),
+		} {
+			if !got.e.Equal(v.e) || got.gen != v.gen || !got.m.Equal(v.m) {
+				t.Errorf("%s = {e: %v, gen: %d, m: %v}; want {e: %v, gen: %d, m: %v}",
+					name, got.e, got.gen, got.m, v.e, v.gen, v.m)
+			}
+		}
+	}
+}
+
+func TestByteViewBytesNoCopy(t *testing.T) {
+	b := []byte("abc")
+	v := of(b)
+	if got := v.BytesNoCopy(); &got[0] != &b[0] {
+		t.Error("BytesNoCopy of a []byte view made a copy")
+	}
+	if got := string(of("abc").BytesNoCopy()); got != "abc" {
+		t.Errorf("BytesNoCopy of string view = %q; want %q", got, "abc")
+	}
+	if !of("").IsEmpty() || !of([]byte{}).IsEmpty() || of("x").IsEmpty() {
+		t.Error("IsEmpty returned the wrong result")
+	}
+}
+
+func TestByteViewAppendTo(t *testing.T) {
+	dst := make([]byte, 0, 16)
+	dst = append(dst, '<')
+	dst = of("abc").AppendTo(dst)
+	dst = of([]byte("def")).AppendTo(dst)
+	if got, want := string(dst), "<abcdef"; got != want {
+		t.Errorf("AppendTo = %q; want %q", got, want)
+	}
+}
+
+func TestByteViewLines(t *testing.T) {
+	tests := []struct {
+		in    string
+		max   int
+		lines string
+	}{
+		{"", 10, "[]"},
+		{"a\nbc\n\nd", 10, "[a bc  d]"},
+		{"a\nbc\n", 10, "[a bc]"},
+		{"a\nbc\nd", 2, "[a bc]"},
+	}
+	for _, tt := range tests {
+		for _, v := range []ByteView{of(tt.in), of([]byte(tt.in))} {
+			var lines []string
+			v.Lines(func(line ByteView) bool {
+				lines = append(lines, line.String())
+				return len(lines) < tt.max
+			})
+			if got := fmt.Sprint(lines); got != tt.lines {
+				t.Errorf("Lines of %q (bytes=%v) = %s; want %s", tt.in, v.b != nil, got, tt.lines)
+			}
+		}
+	}
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
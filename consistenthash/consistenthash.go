@@ -18,7 +18,10 @@ limitations under the License.
 package consistenthash
 
 import (
+	"encoding/binary"
+	"errors"
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -50,6 +53,26 @@ func New(replicas int, fn Hash) *Map {
 	return m
 }
 
+// StaticHash returns a Hash that looks its input up in hashes, and
+// falls back to crc32.ChecksumIEEE for inputs not in it. It makes the
+// placement of items and keys on the ring predictable in tests.
+//
+// The Map hashes replica i of an item as strconv.Itoa(i) followed by
+// the item, so with one replica, items "a" and "b" and
+//
+//	StaticHash(map[string]uint32{"0a": 10, "0b": 20, "x": 5, "y": 15})
+//
+// key "x" maps to "a" and key "y" to "b". The same function can be
+// given to an HTTPPool as HTTPPoolOptions.HashFn.
+func StaticHash(hashes map[string]uint32) Hash {
+	return func(data []byte) uint32 {
+		if h, ok := hashes[string(data)]; ok {
+			return h
+		}
+		return crc32.ChecksumIEEE(data)
+	}
+}
+
 // Returns true if there are no items available.
 func (m *Map) IsEmpty() bool {
 	return len(m.keys) == 0
@@ -61,6 +84,15 @@ func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
 		for i := 0; i < m.replicas; i++ { // 每一个key都会冗余多份（每份冗余就是一致性哈希里的虚拟节点 v-node）
 			hash := int(m.hash([]byte(strconv.Itoa(i) + key))) //虚拟节点的key的哈希值
+			// When replicas of two keys collide, the smaller key owns
+			// the hash, so that the ring does not depend on the order
+			// in which keys were added.
+			if owner, ok := m.hashMap[hash]; ok {
+				if key < owner {
+					m.hashMap[hash] = key
+				}
+				continue
+			}
 			m.keys = append(m.keys, hash) //若有3个节点，最终m.keys就有了3乘以m.replicas个元素
 			m.hashMap[hash] = key
 		}
@@ -89,3 +121,191 @@ func (m *Map) Get(key string) string { //这个key是啥玩意?可能是要根
 
 	return m.hashMap[m.keys[idx]] // 通过hash值，得到节点地址
 }
+
+// GetBounded implements consistent hashing with bounded loads. It is
+// like Get, but skips items whose load has reached factor times the
+// average load, moving on to the next item on the ring. loads holds
+// the caller's current load of each item, such as the number of keys
+// or requests assigned to it; missing items have a load of zero.
+// A factor below 1 is treated as 1.
+func (m *Map) GetBounded(key string, loads map[string]int, factor float64) string {
+	if m.IsEmpty() {
+		return ""
+	}
+	if factor < 1 {
+		factor = 1
+	}
+	total := 0
+	for _, load := range loads {
+		total += load
+	}
+	items := len(m.keys) / m.replicas
+	if items < 1 {
+		items = 1
+	}
+	// Room for one more assignment, spread evenly, times factor.
+	limit := int(math.Ceil(factor * float64(total+1) / float64(items)))
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= hash })
+	for i := 0; i < len(m.keys); i++ {
+		item := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if loads[item] < limit {
+			return item
+		}
+	}
+	return m.hashMap[m.keys[idx%len(m.keys)]]
+}
+
+// Diff returns, for each of keys whose item differs between old and
+// m, the item m assigns it to. Keys whose item did not change are
+// left out. It can be used to plan which values to move after the
+// set of items changes. Neither map is modified.
+func (m *Map) Diff(old *Map, keys []string) map[string]string {
+	moved := make(map[string]string)
+	for _, key := range keys {
+		if item := m.Get(key); item != old.Get(key) {
+			moved[key] = item
+		}
+	}
+	return moved
+}
+
+// GetN returns up to n distinct items in the hash for the provided
+// key, in ring order starting with the one Get returns. Fewer than n
+// items are returned if the hash holds fewer distinct items.
+func (m *Map) GetN(key string, n int) []string {
+	if m.IsEmpty() || n <= 0 {
+		return nil
+	}
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= hash })
+
+	var items []string
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.keys) && len(items) < n; i++ {
+		item := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// A RingEntry is one virtual node on the hash ring.
+type RingEntry struct {
+	Hash int    // position of the virtual node on the ring
+	Node string // item the virtual node belongs to
+}
+
+// Export returns the virtual nodes of the ring in ascending hash
+// order, for inspecting how keys are distributed among the items.
+func (m *Map) Export() []RingEntry {
+	entries := make([]RingEntry, len(m.keys))
+	for i, hash := range m.keys {
+		entries[i] = RingEntry{Hash: hash, Node: m.hashMap[hash]}
+	}
+	return entries
+}
+
+// snapshotVersion is the first byte of the data Snapshot returns.
+const snapshotVersion = 1
+
+var errBadSnapshot = errors.New("consistenthash: malformed snapshot")
+
+// Snapshot returns the ring in a compact binary form that LoadMap
+// restores without hashing or sorting, for instance so that a freshly
+// started node can load a known-good ring. The hash function is not
+// part of the snapshot.
+func (m *Map) Snapshot() []byte {
+	var items []string
+	index := make(map[string]uint64)
+	for _, hash := range m.keys {
+		item := m.hashMap[hash]
+		if _, ok := index[item]; !ok {
+			index[item] = uint64(len(items))
+			items = append(items, item)
+		}
+	}
+	b := []byte{snapshotVersion}
+	b = appendUvarint(b, uint64(m.replicas))
+	b = appendUvarint(b, uint64(len(items)))
+	for _, item := range items {
+		b = appendUvarint(b, uint64(len(item)))
+		b = append(b, item...)
+	}
+	// The hashes are sorted, so store each as the gap from the last.
+	b = appendUvarint(b, uint64(len(m.keys)))
+	prev := 0
+	for _, hash := range m.keys {
+		b = appendUvarint(b, uint64(hash-prev))
+		b = appendUvarint(b, index[m.hashMap[hash]])
+		prev = hash
+	}
+	return b
+}
+
+// LoadMap returns the ring saved by Snapshot, hashing keys with fn,
+// or crc32.ChecksumIEEE if fn is nil, as New does. fn must be the hash
+// of the original Map for the loaded one to route keys the same way.
+func LoadMap(data []byte, fn Hash) (*Map, error) {
+	if len(data) == 0 || data[0] != snapshotVersion {
+		return nil, errors.New("consistenthash: unknown snapshot version")
+	}
+	data = data[1:]
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, false
+		}
+		data = data[n:]
+		return v, true
+	}
+	replicas, ok := next()
+	if !ok || replicas == 0 || replicas > math.MaxInt32 {
+		return nil, errBadSnapshot
+	}
+	nitems, ok := next()
+	if !ok || nitems > uint64(len(data)) {
+		return nil, errBadSnapshot
+	}
+	items := make([]string, nitems)
+	for i := range items {
+		n, ok := next()
+		if !ok || n > uint64(len(data)) {
+			return nil, errBadSnapshot
+		}
+		items[i] = string(data[:n])
+		data = data[n:]
+	}
+	nkeys, ok := next()
+	if !ok || nkeys > uint64(len(data)) {
+		return nil, errBadSnapshot
+	}
+	m := New(int(replicas), fn)
+	m.keys = make([]int, 0, nkeys)
+	hash := uint64(0)
+	for i := uint64(0); i < nkeys; i++ {
+		gap, ok := next()
+		if !ok || (i > 0 && gap == 0) || hash+gap > math.MaxUint32 {
+			return nil, errBadSnapshot
+		}
+		idx, ok := next()
+		if !ok || idx >= nitems {
+			return nil, errBadSnapshot
+		}
+		hash += gap
+		m.keys = append(m.keys, int(hash))
+		m.hashMap[int(hash)] = items[idx]
+	}
+	if len(data) != 0 {
+		return nil, errBadSnapshot
+	}
+	return m, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
@@ -18,6 +18,8 @@ package consistenthash
 
 import (
 	"fmt"
+	"hash/crc32"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -108,3 +110,253 @@ func benchmarkGet(b *testing.B, shards int) {
 		hash.Get(buckets[i&(shards-1)])
 	}
 }
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+
+	// Replicas with "hashes": 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := []struct {
+		key  string
+		n    int
+		want []string
+	}{
+		{"11", 1, []string{"2"}},
+		{"11", 2, []string{"2", "4"}},
+		{"23", 3, []string{"4", "6", "2"}},
+		{"27", 5, []string{"2", "4", "6"}},
+		{"27", 0, nil},
+	}
+	for _, tc := range testCases {
+		if got := hash.GetN(tc.key, tc.n); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("GetN(%s, %d) = %v; want %v", tc.key, tc.n, got, tc.want)
+		}
+		if tc.n > 0 && hash.GetN(tc.key, tc.n)[0] != hash.Get(tc.key) {
+			t.Errorf("GetN(%s, %d)[0] differs from Get", tc.key, tc.n)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+	want := "[{2 2} {4 4} {6 6} {12 2} {14 4} {16 6} {22 2} {24 4} {26 6}]"
+	if got := fmt.Sprint(hash.Export()); got != want {
+		t.Errorf("Export = %s; want %s", got, want)
+	}
+}
+
+func TestGetBounded(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+	hash.Add("6", "4", "2")
+
+	if got, want := hash.GetBounded("11", nil, 1.25), hash.Get("11"); got != want {
+		t.Errorf("GetBounded with no load = %q; want %q", got, want)
+	}
+	loads := map[string]int{"2": 5}
+	if got := hash.GetBounded("11", loads, 1.25); got != "4" {
+		t.Errorf("GetBounded with overloaded owner = %q; want %q", got, "4")
+	}
+
+	// Assigning many keys keeps every item within its bound.
+	hash = New(50, nil)
+	hash.Add("a", "b", "c")
+	loads = map[string]int{}
+	for i := 0; i < 300; i++ {
+		loads[hash.GetBounded(fmt.Sprintf("key-%d", i), loads, 1.25)]++
+	}
+	for item, n := range loads {
+		if n > 125 {
+			t.Errorf("item %q has load %d; want <= 125", item, n)
+		}
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	// Hash only the replica number, so that every replica of one key
+	// collides with the same replica of every other key.
+	collide := func(key []byte) uint32 { return uint32(key[0]) }
+	h1 := New(2, collide)
+	h1.Add("b", "a", "c")
+	h2 := New(2, collide)
+	h2.Add("c", "b")
+	h2.Add("a")
+
+	for _, h := range []*Map{h1, h2} {
+		if got, want := fmt.Sprint(h.Export()), "[{48 a} {49 a}]"; got != want {
+			t.Errorf("Export = %s; want %s", got, want)
+		}
+		if got := h.Get("x"); got != "a" {
+			t.Errorf("Get(x) = %q; want %q", got, "a")
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := New(50, nil)
+	old.Add("a", "b", "c")
+	m := New(50, nil)
+	m.Add("a", "b", "c", "d")
+
+	var keys []string
+	for i := 0; i < 200; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i))
+	}
+	moved := m.Diff(old, keys)
+	if len(moved) == 0 {
+		t.Fatal("no key moved to the added item")
+	}
+	for _, key := range keys {
+		owner, ok := moved[key]
+		if changed := m.Get(key) != old.Get(key); changed != ok {
+			t.Errorf("key %q: in Diff = %v; want %v", key, ok, changed)
+		}
+		if ok && owner != "d" {
+			t.Errorf("key %q moved to %q; want %q", key, owner, "d")
+		}
+	}
+	if moved := m.Diff(m, keys); len(moved) != 0 {
+		t.Errorf("Diff with itself = %v; want none", moved)
+	}
+}
+
+func TestStaticHash(t *testing.T) {
+	hash := New(1, StaticHash(map[string]uint32{"0a": 10, "0b": 20, "x": 5, "y": 15}))
+	hash.Add("a", "b")
+	for key, want := range map[string]string{"x": "a", "y": "b"} {
+		if got := hash.Get(key); got != want {
+			t.Errorf("Get(%q) = %q; want %q", key, got, want)
+		}
+	}
+	if got, want := StaticHash(nil)([]byte("z")), crc32.ChecksumIEEE([]byte("z")); got != want {
+		t.Errorf("hash of an unlisted input = %d; want %d", got, want)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m := New(50, nil)
+	m.Add("http://a", "http://b", "http://c")
+
+	data := m.Snapshot()
+	loaded, err := LoadMap(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Export(), m.Export()) {
+		t.Error("loaded ring differs from the original")
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if got, want := loaded.Get(key), m.Get(key); got != want {
+			t.Errorf("Get(%q) = %q; want %q", key, got, want)
+		}
+	}
+	// The loaded map keeps its replica count for later Adds.
+	m.Add("http://d")
+	loaded.Add("http://d")
+	if !reflect.DeepEqual(loaded.Export(), m.Export()) {
+		t.Error("rings differ after adding an item")
+	}
+
+	// A replica count of zero, or one too large for an int32.
+	zeroReplicas := []byte{data[0], 0, 0, 0}
+	manyReplicas := []byte{data[0], 0x80, 0x80, 0x80, 0x80, 0x10, 0, 0}
+	for _, bad := range [][]byte{nil, {0}, data[:len(data)-1], append(data[:len(data):len(data)], 0), zeroReplicas, manyReplicas} {
+		if _, err := LoadMap(bad, nil); err == nil {
+			t.Errorf("LoadMap(%d bytes) succeeded; want an error", len(bad))
+		}
+	}
+
+	empty, err := LoadMap(New(3, nil).Snapshot(), nil)
+	if err != nil || !empty.IsEmpty() {
+		t.Errorf("LoadMap of an empty ring = %v, %v; want an empty map", empty, err)
+	}
+}
+
+func TestSnapshotCustomHash(t *testing.T) {
+	m := New(50, XXHash)
+	m.Add("http://a", "http://b", "http://c")
+	loaded, err := LoadMap(m.Snapshot(), XXHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if got, want := loaded.Get(key), m.Get(key); got != want {
+			t.Errorf("Get(%q) = %q; want %q", key, got, want)
+		}
+	}
+}
+
+func TestXXHash(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	} {
+		if got := xxhash64([]byte(tt.in)); got != tt.want {
+			t.Errorf("xxhash64(%q) = %#x; want %#x", tt.in, got, tt.want)
+		}
+		if got, want := XXHash([]byte(tt.in)), uint32(tt.want); got != want {
+			t.Errorf("XXHash(%q) = %#x; want %#x", tt.in, got, want)
+		}
+	}
+}
+
+// maxLoad returns the largest share of keys that one of items gets on
+// a ring built with fn, relative to an even share.
+func maxLoad(fn Hash, items []string, keys int) float64 {
+	m := New(50, fn)
+	m.Add(items...)
+	load := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		load[m.Get("key"+strconv.Itoa(i))]++
+	}
+	max := 0
+	for _, n := range load {
+		if n > max {
+			max = n
+		}
+	}
+	return float64(max) * float64(len(items)) / float64(keys)
+}
+
+func TestXXHashDistribution(t *testing.T) {
+	var items []string
+	for i := 0; i < 8; i++ {
+		items = append(items, fmt.Sprintf("10.0.0.%d:8080", i+1))
+	}
+	const keys = 100000
+	crc := maxLoad(crc32.ChecksumIEEE, items, keys)
+	xx := maxLoad(XXHash, items, keys)
+	t.Logf("max load relative to an even share: crc32 %.3f, xxhash %.3f", crc, xx)
+	if xx > crc {
+		t.Errorf("xxhash max load %.3f is worse than crc32's %.3f", xx, crc)
+	}
+	if xx > 1.25 {
+		t.Errorf("xxhash max load %.3f; want <= 1.25", xx)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// getters.go provides Getter implementations composed from other Getters.
+
+package groupcache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// FallbackGetter returns a Getter that tries each of getters in order
+// and uses the value of the first one that succeeds. If all of them
+// fail, the error of the last one is returned.
+//
+// Each getter writes into a scratch Sink, so dest is only populated
+// once, by the getter that succeeded.
+func FallbackGetter(getters ...Getter) Getter {
+	return GetterFunc(func(ctx Context, key string, dest Sink) error {
+		err := errors.New("groupcache: FallbackGetter has no getters")
+		for _, g := range getters {
+			var v ByteView
+			if err = g.Get(ctx, key, ByteViewSink(&v)); err == nil {
+				return setSinkView(dest, v)
+			}
+		}
+		return err
+	})
+}
+
+// GroupGetter returns a Getter that loads values from g, so that g
+// can back another Group as a further tier of cache in the same
+// process. The Context is passed on to g. The value, along with any
+// expiry time it has in g, reaches dest only if g's Get succeeds.
+func GroupGetter(g *Group) Getter {
+	return GetterFunc(func(ctx Context, key string, dest Sink) error {
+		var v ByteView
+		if err := g.Get(ctx, key, ByteViewSink(&v)); err != nil {
+			return err
+		}
+		return setSinkView(dest, v)
+	})
+}
+
+// ErrGetterTimeout is returned by a TimeoutGetter whose inner Getter
+// did not finish in time.
+var ErrGetterTimeout = errors.New("groupcache: getter timed out")
+
+// TimeoutGetter returns a Getter that fails with ErrGetterTimeout if
+// g does not return within d. A d of zero or less means no timeout,
+// and g is returned as is.
+//
+// g cannot be interrupted, so after a timeout it keeps running in the
+// background until it returns, and its result is discarded. g writes
+// into a scratch Sink, so dest is never touched after a timeout.
+func TimeoutGetter(g Getter, d time.Duration) Getter {
+	if d <= 0 {
+		return g
+	}
+	return GetterFunc(func(ctx Context, key string, dest Sink) error {
+		type result struct {
+			v   ByteView
+			err error
+		}
+		done := make(chan result, 1)
+		go func() {
+			var v ByteView
+			err := g.Get(ctx, key, ByteViewSink(&v))
+			done <- result{v, err}
+		}()
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case r := <-done:
+			if r.err != nil {
+				return r.err
+			}
+			return setSinkView(dest, r.v)
+		case <-t.C:
+			return ErrGetterTimeout
+		}
+	})
+}
+
+// ErrorCachingGetter returns a Getter that remembers the errors of
+// inner for ttl and returns a remembered error for a key instead of
+// calling inner again. A successful load forgets the key's error.
+// At most max errors are kept, evicting the least recently used; if
+// max is zero or less, 1024 are kept.
+//
+// The errors are kept apart from any Group's caches, so they neither
+// use up cache space nor show in its stats.
+func ErrorCachingGetter(inner Getter, ttl time.Duration, max int) Getter {
+	errs := &errorCache{max: max}
+	return GetterFunc(func(ctx Context, key string, dest Sink) error {
+		if err := errs.get(key, time.Now()); err != nil {
+			return err
+		}
+		err := inner.Get(ctx, key, dest)
+		if err != nil {
+			errs.add(key, err, time.Now().Add(ttl))
+		} else {
+			errs.remove(key)
+		}
+		return err
+	})
+}
+
+// Chain returns g wrapped in the middleware mw, such as
+// TimingMiddleware and RecoverMiddleware, so that concerns like
+// logging, metrics or authorization apply to every load. The first
+// middleware is the outermost: Chain(g, a, b) is a(b(g)).
+func Chain(g Getter, mw ...func(Getter) Getter) Getter {
+	for i := len(mw) - 1; i >= 0; i-- {
+		g = mw[i](g)
+	}
+	return g
+}
+
+// TimingMiddleware returns a middleware for Chain that calls fn after
+// each load with the key, how long the load took and its error.
+func TimingMiddleware(fn func(key string, d time.Duration, err error)) func(Getter) Getter {
+	return func(g Getter) Getter {
+		return GetterFunc(func(ctx Context, key string, dest Sink) error {
+			start := time.Now()
+			err := g.Get(ctx, key, dest)
+			fn(key, time.Since(start), err)
+			return err
+		})
+	}
+}
+
+// RecoverMiddleware returns a middleware for Chain that turns a
+// panic in the Getter into an error for the key, instead of letting
+// it crash the process.
+func RecoverMiddleware() func(Getter) Getter {
+	return func(g Getter) Getter {
+		return GetterFunc(func(ctx Context, key string, dest Sink) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("groupcache: getter panicked loading %q: %v", key, r)
+				}
+			}()
+			return g.Get(ctx, key, dest)
+		})
+	}
+}
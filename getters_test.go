@@ -0,0 +1,176 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFallbackGetter(t *testing.T) {
+	errFast := errors.New("fast source down")
+	errSlow := errors.New("slow source down")
+	fast := GetterFunc(func(_ Context, key string, dest Sink) error {
+		dest.SetString("partial")
+		return errFast
+	})
+	slow := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("slow:" + key)
+	})
+	failing := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errSlow
+	})
+
+	var s string
+	if err := FallbackGetter(fast, slow).Get(dummyCtx, "k", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "slow:k" {
+		t.Errorf("got %q; want %q", s, "slow:k")
+	}
+
+	s = ""
+	if err := FallbackGetter(fast, failing).Get(dummyCtx, "k", StringSink(&s)); err != errSlow {
+		t.Errorf("error = %v; want %v", err, errSlow)
+	}
+	if s != "" {
+		t.Errorf("dest was set to %q by a failing getter", s)
+	}
+}
+
+func TestTimeoutGetter(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := GetterFunc(func(_ Context, key string, dest Sink) error {
+		<-release
+		return dest.SetString("late")
+	})
+	fast := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("fast:" + key)
+	})
+
+	var s string
+	if err := TimeoutGetter(slow, 10*time.Millisecond).Get(dummyCtx, "k", StringSink(&s)); err != ErrGetterTimeout {
+		t.Errorf("slow getter error = %v; want ErrGetterTimeout", err)
+	}
+	if err := TimeoutGetter(fast, time.Second).Get(dummyCtx, "k", StringSink(&s)); err != nil || s != "fast:k" {
+		t.Errorf("fast getter = %q, %v; want %q, nil", s, err, "fast:k")
+	}
+	if err := TimeoutGetter(fast, 0).Get(dummyCtx, "z", StringSink(&s)); err != nil || s != "fast:z" {
+		t.Errorf("getter without timeout = %q, %v; want %q, nil", s, err, "fast:z")
+	}
+}
+
+func TestErrorCachingGetter(t *testing.T) {
+	var calls int
+	fail := true
+	inner := GetterFunc(func(_ Context, key string, dest Sink) error {
+		calls++
+		if fail {
+			return errors.New("source down")
+		}
+		return dest.SetString("v")
+	})
+	g := ErrorCachingGetter(inner, time.Hour, 1)
+	var s string
+	for i := 0; i < 3; i++ {
+		if err := g.Get(dummyCtx, "a", StringSink(&s)); err == nil {
+			t.Fatal("Get succeeded with a failing getter")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("inner called %d times; want 1", calls)
+	}
+
+	// A second key evicts the error of the first, since max is 1.
+	g.Get(dummyCtx, "b", StringSink(&s))
+	fail = false
+	if err := g.Get(dummyCtx, "a", StringSink(&s)); err != nil || s != "v" {
+		t.Errorf("Get after eviction = %q, %v; want %q, nil", s, err, "v")
+	}
+	if calls != 3 {
+		t.Errorf("inner called %d times; want 3", calls)
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	tag := func(name string) func(Getter) Getter {
+		return func(g Getter) Getter {
+			return GetterFunc(func(ctx Context, key string, dest Sink) error {
+				order = append(order, name)
+				return g.Get(ctx, key, dest)
+			})
+		}
+	}
+	var timed []string
+	timing := TimingMiddleware(func(key string, d time.Duration, err error) {
+		timed = append(timed, fmt.Sprintf("%s:%v", key, err))
+	})
+	g := Chain(GetterFunc(func(_ Context, key string, dest Sink) error {
+		if key == "boom" {
+			panic("boom")
+		}
+		return dest.SetString("v:" + key)
+	}), timing, RecoverMiddleware(), tag("a"), tag("b"))
+
+	var s string
+	if err := g.Get(dummyCtx, "k", StringSink(&s)); err != nil || s != "v:k" {
+		t.Errorf("Get = %q, %v; want %q, nil", s, err, "v:k")
+	}
+	if got := strings.Join(order, ","); got != "a,b" {
+		t.Errorf("middleware order = %q; want %q", got, "a,b")
+	}
+	err := g.Get(dummyCtx, "boom", StringSink(&s))
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Errorf("Get of a panicking getter = %v; want a panic error", err)
+	}
+	if len(timed) != 2 || timed[0] != "k:<nil>" || !strings.HasPrefix(timed[1], "boom:") || timed[1] == "boom:<nil>" {
+		t.Errorf("timed loads = %q; want k with no error, then boom with one", timed)
+	}
+}
+
+func TestGroupGetter(t *testing.T) {
+	type ctxKey struct{}
+	loads := 0
+	lower := newGroup("TestGroupGetter-lower", 1<<20, GetterFunc(func(ctx Context, key string, dest Sink) error {
+		loads++
+		c := ctx.(context.Context)
+		return dest.SetBytesWithExpiry([]byte(fmt.Sprint(c.Value(ctxKey{}), ":", key)), time.Now().Add(time.Hour))
+	}), NoPeers{})
+	upper := newGroup("TestGroupGetter-upper", 1<<20, GroupGetter(lower), NoPeers{})
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "req")
+	var s string
+	if err := upper.Get(ctx, "k", StringSink(&s)); err != nil || s != "req:k" {
+		t.Fatalf("Get = %q, %v; want %q, nil", s, err, "req:k")
+	}
+	v, ok := upper.GetFromCache("k", MainCache)
+	if !ok || v.e.IsZero() {
+		t.Errorf("upper cache holds %q, %v with expiry %v; want the lower value and its expiry", v.String(), ok, v.e)
+	}
+	if _, ok := lower.GetFromCache("k", MainCache); !ok {
+		t.Error("lower group did not cache the value")
+	}
+	if loads != 1 {
+		t.Errorf("loads = %d; want 1", loads)
+	}
+}
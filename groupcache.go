@@ -25,11 +25,17 @@ limitations under the License.
 package groupcache
 
 import (
+	"context"
 	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	pb "groupcache/groupcachepb"
 	"groupcache/lru"
@@ -47,6 +53,34 @@ type Getter interface {
 	Get(ctx Context, key string, dest Sink) error
 }
 
+// A Logger receives the errors a Group encounters while loading
+// values, which are otherwise only visible as Stats counters.
+// Its methods are called synchronously on the load path and should
+// return quickly.
+type Logger interface {
+	// PeerError is called when fetching key from a peer fails.
+	// The key is then loaded locally.
+	PeerError(key string, err error)
+
+	// LoadError is called when loading key with the group's
+	// Getter fails.
+	LoadError(key string, err error)
+}
+
+// A ReaderGetter loads data for a key as a stream, which avoids
+// materializing very large values in the getter before they are
+// cached. A Getter passed to NewGroup that also implements
+// ReaderGetter is loaded through GetReader instead of Get.
+//
+// If the group caches values, a value too big to fit in its cache
+// fails with ErrValueTooLarge rather than being read into memory.
+type ReaderGetter interface {
+	// GetReader returns a reader for the value identified by key,
+	// and the value's size in bytes, or -1 if it is not known.
+	// The group closes the reader.
+	GetReader(ctx Context, key string) (r io.ReadCloser, size int64, err error)
+}
+
 // A GetterFunc implements Getter with a function.
 type GetterFunc func(ctx Context, key string, dest Sink) error
 
@@ -80,12 +114,42 @@ func GetGroup(name string) *Group {
 // completes.
 //
 // The group name must be unique for each getter.
+//
+// cacheBytes limits the combined size of the group's caches. A
+// cacheBytes of zero or less disables caching: every Get that misses
+// the peers loads the value again. See Group.CachingEnabled.
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 	return newGroup(name, cacheBytes, getter, nil)
 }
 
+// GroupOptions are the configurations of a Group created with
+// NewGroupOpts.
+type GroupOptions struct {
+	// MainCacheBytes limits the size of the mainCache, which holds
+	// the keys this process owns.
+	MainCacheBytes int64
+
+	// HotCacheBytes limits the size of the hotCache, which mirrors
+	// popular keys owned by other peers. If zero, the hotCache is
+	// disabled.
+	HotCacheBytes int64
+}
+
+// NewGroupOpts is like NewGroup, but gives the mainCache and the
+// hotCache separate budgets from o instead of one shared cacheBytes.
+// Each cache evicts independently when it exceeds its own budget.
+func NewGroupOpts(name string, getter Getter, o *GroupOptions) *Group {
+	return newGroupOpts(name, 0, getter, nil, o)
+}
+
 // If peers is nil, the peerPicker is called via a sync.Once to initialize it.
 func newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker) *Group {
+	return newGroupOpts(name, cacheBytes, getter, peers, nil)
+}
+
+// newGroupOpts creates a group with a cacheBytes budget shared by
+// both caches or, if o is non-nil, with the budgets in o.
+func newGroupOpts(name string, cacheBytes int64, getter Getter, peers PeerPicker, o *GroupOptions) *Group {
 	if getter == nil { //需要先判断一下这个分组存在与否,重复创建分组,会panic.
 		panic("nil Getter")
 	}
@@ -96,11 +160,23 @@ func newGroup(name string, cacheBytes int64, getter Getter, peers PeerPicker) *G
 		panic("duplicate registration of group " + name)
 	}
 	g := &Group{
-		name:       name,
-		getter:     getter,
-		peers:      peers, //nil
-		cacheBytes: cacheBytes,
-		loadGroup:  &singleflight.Group{},
+		name:         name,
+		getter:       getter,
+		peers:        peers, //nil
+		cacheBytes:   cacheBytes,
+		loadGroup:    &singleflight.Group{},
+		refreshGroup: &singleflight.Group{},
+	}
+	if o != nil {
+		g.separateBudgets = true
+		if o.MainCacheBytes > 0 {
+			g.mainCacheBytes = o.MainCacheBytes
+		}
+		if o.HotCacheBytes > 0 {
+			g.hotCacheBytes = o.HotCacheBytes
+		}
+		g.cacheBytes = g.mainCacheBytes + g.hotCacheBytes
+		g.disableHotCache = g.hotCacheBytes == 0
 	}
 	if fn := newGroupHook; fn != nil {
 		fn(g)
@@ -173,6 +249,244 @@ type Group struct {
 
 	// Stats are statistics on the group.
 	Stats Stats
+
+	// Fields below are configuration. They are kept after Stats so
+	// they do not disturb its alignment.
+
+	// disableHotCache, if true, prevents values fetched from peers
+	// from being mirrored into hotCache. See DisableHotCache.
+	disableHotCache bool
+
+	// separateBudgets reports whether the group was created with
+	// NewGroupOpts, in which case mainCacheBytes and hotCacheBytes
+	// limit the two caches independently and cacheBytes is their sum.
+	separateBudgets bool
+	mainCacheBytes  int64
+	hotCacheBytes   int64
+
+	// CacheableFunc optionally reports whether the value for key may
+	// be stored in the group's caches. Values for keys it rejects are
+	// still loaded (with duplicate suppression) and returned, but are
+	// never cached. If nil, all values are cached.
+	CacheableFunc func(key string) bool
+
+	// loadLimit limits the rate of local loads. See SetLoadRateLimit.
+	loadLimit tokenBucket
+
+	// breaker stops local loads after repeated failures.
+	// See SetCircuitBreaker.
+	breaker circuitBreaker
+
+	// ttl is how long cached values live. Zero means forever.
+	// See SetTTL.
+	ttl time.Duration
+
+	// refreshAhead is the fraction of ttl after which a cache hit
+	// triggers a background refresh. Zero disables refresh-ahead.
+	// See SetRefreshAhead.
+	refreshAhead float64
+
+	// refreshGroup ensures that each key is only refreshed once at
+	// a time, independently of loadGroup.
+	refreshGroup flightGroup
+
+	// Logger optionally receives peer and local load errors.
+	Logger Logger
+
+	// errorTTL is how long a local load error is remembered.
+	// Zero disables error caching. See SetErrorTTL.
+	errorTTL time.Duration
+
+	// errCache holds recent local load errors when errorTTL is set.
+	errCache errorCache
+
+	// MaxKeyBytes, if positive, is the longest key the group
+	// accepts. Get returns ErrKeyTooLong for longer keys, and
+	// HTTPPool rejects them with 400 Bad Request.
+	MaxKeyBytes int
+
+	// GenerationFunc, if non-nil, returns the current generation of
+	// key. The owner of a key reports the generation alongside the
+	// value, and values mirrored in the hotCache with an older
+	// generation than GenerationFunc returns are dropped instead of
+	// served. It should be set on every peer before the group is used.
+	GenerationFunc func(key string) uint64
+
+	// getterMu guards getter against SetGetter.
+	getterMu sync.RWMutex
+
+	// ServeStaleOnError makes Get fall back to an expired value when
+	// a fresh one cannot be loaded, returning a *StaleError along
+	// with it. Expired values are then kept in the caches until they
+	// are evicted or replaced, instead of being dropped on access.
+	ServeStaleOnError bool
+
+	// hotKeyQPS is the request rate above which a key is promoted
+	// to requesters' hotCaches. See SetHotKeyQPS.
+	hotKeyQPS float64
+	keyRates  keyRates
+
+	// OnLoadComplete, if non-nil, is called after every attempt to
+	// load a value, with source "local" for the group's Getter and
+	// "peer" for a fetch from another peer, and how long it took.
+	// Failed attempts are reported too. It can feed a latency
+	// histogram, complementing the counters in Stats.
+	OnLoadComplete func(key string, source string, d time.Duration)
+
+	// PushToOwnerOnLoad makes the group send a value it loaded
+	// locally for a key owned by another peer (after fetching from
+	// that peer failed) to the owner, if the owner is a Pusher, so
+	// that the owner's next request for the key is a cache hit.
+	// The push happens in the background; errors are logged as peer
+	// errors. The owner's group must set AcceptPushes.
+	PushToOwnerOnLoad bool
+
+	// AcceptPushes makes the group's HTTPPool accept values pushed by
+	// peers with PushToOwnerOnLoad. Only keys this peer owns and
+	// values no bigger than the group's cacheBytes are accepted. Any
+	// client that can reach the pool can then fill the main cache, so
+	// it should only be set when the pool is not reachable by
+	// untrusted clients.
+	AcceptPushes bool
+
+	// RetryReplicaOnDecodeError makes the group, when a peer answers
+	// with a value that cannot be decoded (a *PeerDecodeError), ask
+	// the key's next replica owner before loading the value locally.
+	// It requires the group's PeerPicker to be a ReplicaPicker.
+	RetryReplicaOnDecodeError bool
+
+	// CancelAbandonedLoads makes a load started by a Get whose
+	// Context is a context.Context run with a context of its own,
+	// which is canceled once every caller waiting for the load has
+	// given up because its context is done. Getters that honor the
+	// context then stop early, and the result of such an abandoned
+	// load is neither cached nor remembered as an error.
+	//
+	// The Getter then writes to a Sink of the group's instead of the
+	// caller's, which costs a copy of each loaded value.
+	CancelAbandonedLoads bool
+
+	// EvictOnRebalance makes the group call RemoveUnowned whenever
+	// its PeerPicker is an HTTPPool whose membership Set changes, so
+	// that it stops holding keys other peers now own.
+	EvictOnRebalance bool
+
+	// ReclassifyHotOnRebalance makes the group call
+	// ReclassifyHotCache whenever its PeerPicker is an HTTPPool whose
+	// membership Set changes, so that keys it now owns move from the
+	// hotCache to the mainCache.
+	ReclassifyHotOnRebalance bool
+
+	// clock tells the time for TTLs, error TTLs and MaxIdle. See
+	// SetClock.
+	clock Clock
+
+	// evictionPolicy chooses which cache to evict from when the
+	// group is over budget. See SetEvictionPolicy.
+	evictionPolicy func(mainBytes, hotBytes int64) CacheType
+
+	// PeerFallbackMode controls whether a local load of a key owned
+	// by another peer waits for that peer to fail, or races it after
+	// HedgeDelay. RetryReplicaOnDecodeError and PushToOwnerOnLoad
+	// only apply to PeerFallbackSerial.
+	PeerFallbackMode PeerFallbackMode
+
+	// HedgeDelay is how long a PeerFallbackHedged group waits for the
+	// owning peer before also loading the key itself. Zero starts
+	// both at once.
+	HedgeDelay time.Duration
+
+	// LoadTimeout, if positive, bounds how long a Get waits for a
+	// load, so that a Getter or peer that hangs does not hang every
+	// Get of the key with it. A Get that waits longer fails with
+	// singleflight.ErrTimeout, and the next Get of the key starts a
+	// new load while the old one finishes in the background. The
+	// load's context is canceled after LoadTimeout, and as with
+	// CancelAbandonedLoads, the Getter writes to a copy rather than
+	// to the caller's Sink. It takes precedence over
+	// CancelAbandonedLoads, and waiting Gets no longer stop when their
+	// Context is done.
+	LoadTimeout time.Duration
+
+	// MaxIdle, if positive, evicts cached values that no Get has
+	// used for that long, even when the caches are not full, so that
+	// memory held by keys that went cold is reclaimed. Idle values
+	// are swept lazily, as Gets look up the cache, and count as
+	// evictions. Use times are only recorded once MaxIdle is set, so
+	// values cached before then count as used when it takes effect.
+	// It has no effect on caches made by SetCacheFactory.
+	MaxIdle time.Duration
+}
+
+// Load sources reported to OnLoadComplete.
+const (
+	LoadSourceLocal = "local"
+	LoadSourcePeer  = "peer"
+)
+
+// generation returns the current generation of key, or nil if the
+// group has no GenerationFunc.
+func (g *Group) generation(key string) *uint64 {
+	if g.GenerationFunc == nil {
+		return nil
+	}
+	gen := g.GenerationFunc(key)
+	return &gen
+}
+
+// peerResponse builds the response to a peer's request for key. It
+// tells the peer how long it may cache value, if the value expires,
+// and whether to mirror it, if the group tracks key rates.
+func (g *Group) peerResponse(key string, value ByteView) *pb.GetResponse {
+	res := g.peerMeta(key, value)
+	res.Value = value.ByteSlice()
+	return res
+}
+
+// peerMeta is like peerResponse, but leaves out the value itself.
+func (g *Group) peerMeta(key string, value ByteView) *pb.GetResponse {
+	res := &pb.GetResponse{Generation: g.generation(key)}
+	now := g.now()
+	ttl := g.ttl
+	if !value.e.IsZero() && (ttl <= 0 || value.e.Sub(now) < ttl) {
+		ttl = value.e.Sub(now)
+	}
+	if ttl > 0 {
+		ms := int64((ttl + time.Millisecond - 1) / time.Millisecond)
+		res.TtlMs = &ms
+	}
+	if g.hotKeyQPS > 0 {
+		qps := g.keyRates.hit(key, now)
+		hot := qps >= g.hotKeyQPS
+		res.MinuteQps = &qps
+		res.Hot = &hot
+	}
+	return res
+}
+
+// SetHotKeyQPS makes the group track how often peers request each
+// key it owns, and report the rate with each response. A peer that
+// receives a rate of at least qps requests per second always mirrors
+// the value in its hotCache, instead of only some of the time, which
+// spreads the load of very popular keys away from their owner. The
+// owner also tells peers whether the rate reached its own qps; peers
+// mirror the value exactly when it did, unless the rate reaches their
+// own qps. A qps of zero or less disables this, which is the default.
+// It should be called on every peer before the group is used.
+func (g *Group) SetHotKeyQPS(qps float64) {
+	g.hotKeyQPS = qps
+}
+
+// ErrKeyTooLong is returned by Get when the key is longer than the
+// group's MaxKeyBytes.
+var ErrKeyTooLong = errors.New("groupcache: key too long")
+
+// checkKey returns ErrKeyTooLong if key exceeds MaxKeyBytes.
+func (g *Group) checkKey(key string) error {
+	if g.MaxKeyBytes > 0 && len(key) > g.MaxKeyBytes {
+		return ErrKeyTooLong
+	}
+	return nil
 }
 
 // flightGroup is defined as an interface which flightgroup.Group
@@ -183,6 +497,30 @@ type flightGroup interface {
 	Do(key string, fn func() (interface{}, error)) (interface{}, error)
 }
 
+// timeoutFlightGroup is implemented by flightGroups whose callers can
+// stop waiting after a timeout.
+type timeoutFlightGroup interface {
+	DoWithTimeout(key string, fn func() (interface{}, error), d time.Duration) (interface{}, error)
+}
+
+// pendingFlightGroup is implemented by flightGroups that can tell
+// whether a call is in flight.
+type pendingFlightGroup interface {
+	Pending(key string) bool
+}
+
+// contextFlightGroup is implemented by flightGroups whose duplicate
+// callers can stop waiting when their context is done.
+type contextFlightGroup interface {
+	DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error)
+}
+
+// sharedFlightGroup is implemented by flightGroups that can abandon
+// a call once all of its callers have given up.
+type sharedFlightGroup interface {
+	DoShared(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (interface{}, error)
+}
+
 // Stats are per-group statistics.
 type Stats struct {
 	Gets           AtomicInt // any Get request, including from peers
@@ -196,11 +534,162 @@ type Stats struct {
 	ServerRequests AtomicInt // gets that came over the network from peers
 }
 
+// StatsSnapshot is a point-in-time copy of a Group's Stats and the
+// CacheStats of both of its caches.
+type StatsSnapshot struct {
+	Gets           int64 `json:"gets"`
+	CacheHits      int64 `json:"cache_hits"`
+	PeerLoads      int64 `json:"peer_loads"`
+	PeerErrors     int64 `json:"peer_errors"`
+	Loads          int64 `json:"loads"`
+	LoadsDeduped   int64 `json:"loads_deduped"`
+	LocalLoads     int64 `json:"local_loads"`
+	LocalLoadErrs  int64 `json:"local_load_errs"`
+	ServerRequests int64 `json:"server_requests"`
+
+	MainCache CacheStats `json:"main_cache"`
+	HotCache  CacheStats `json:"hot_cache"`
+}
+
+// Snapshot returns a copy of the group's statistics. Each counter is
+// loaded atomically, so the result can be read without further
+// synchronization.
+func (g *Group) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Gets:           g.Stats.Gets.Get(),
+		CacheHits:      g.Stats.CacheHits.Get(),
+		PeerLoads:      g.Stats.PeerLoads.Get(),
+		PeerErrors:     g.Stats.PeerErrors.Get(),
+		Loads:          g.Stats.Loads.Get(),
+		LoadsDeduped:   g.Stats.LoadsDeduped.Get(),
+		LocalLoads:     g.Stats.LocalLoads.Get(),
+		LocalLoadErrs:  g.Stats.LocalLoadErrs.Get(),
+		ServerRequests: g.Stats.ServerRequests.Get(),
+		MainCache:      g.mainCache.stats(),
+		HotCache:       g.hotCache.stats(),
+	}
+}
+
 // Name returns the name of the group.
 func (g *Group) Name() string {
 	return g.name
 }
 
+// SetGetter replaces the Getter the group loads values with, for
+// instance after a configuration reload. Loads that start afterwards
+// use the new Getter; loads already running finish with the old one.
+// Values already cached are not affected.
+func (g *Group) SetGetter(getter Getter) {
+	if getter == nil {
+		panic("nil Getter")
+	}
+	g.getterMu.Lock()
+	g.getter = getter
+	g.getterMu.Unlock()
+}
+
+// CachingEnabled reports whether the group caches values at all. It
+// is false for groups created with a cacheBytes of zero or less.
+func (g *Group) CachingEnabled() bool {
+	return g.cacheBytes > 0
+}
+
+// DisableHotCache stops the group from mirroring values owned by
+// other peers in its hotCache, leaving the whole cacheBytes budget to
+// mainCache. It is intended for deployments where every node owns a
+// disjoint set of keys. It must be called before the group is used.
+func (g *Group) DisableHotCache() {
+	g.disableHotCache = true
+}
+
+// SetLoadRateLimit limits the number of local loads (calls to the
+// group's Getter) to perSec per second, with bursts of up to perSec.
+// When the limit is reached, loads wait for their turn if block is
+// true, or fail with ErrLoadRateLimited otherwise. A waiting load
+// gives up with the Context's error once its Context is done. Tokens
+// accrue on the group's clock; see SetClock. A perSec of zero or less
+// removes the limit, which is the default.
+func (g *Group) SetLoadRateLimit(perSec int, block bool) {
+	g.loadLimit.set(perSec, block)
+}
+
+// SetCircuitBreaker protects the group's Getter during outages. After
+// failures consecutive local load errors within window, Gets that need
+// a local load fail fast with ErrCircuitOpen for cooldown. Then a
+// single load is let through as a probe: if it succeeds, loads resume,
+// otherwise the circuit stays open for another cooldown. A failures of
+// zero or less disables the breaker, which is the default.
+func (g *Group) SetCircuitBreaker(failures int, window, cooldown time.Duration) {
+	g.breaker.set(failures, window, cooldown)
+}
+
+// SetTTL makes values cached by the group expire ttl after they
+// were stored. Expired values are dropped lazily, on lookup. A ttl of
+// zero, the default, caches values until they are evicted.
+// It must be called before the group is used.
+func (g *Group) SetTTL(ttl time.Duration) {
+	g.ttl = ttl
+}
+
+// A Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// SetClock makes the group read the time from c when it applies TTLs,
+// error TTLs, refresh-ahead, MaxIdle, the load rate limit and the
+// circuit breaker's cooldown, so that tests can advance time without sleeping. A nil c, the default, uses the real clock.
+// It must be called before the group is used.
+func (g *Group) SetClock(c Clock) {
+	g.clock = c
+	g.mainCache.clock = c
+	g.hotCache.clock = c
+}
+
+// now returns the current time according to the group's clock.
+func (g *Group) now() time.Time {
+	if g.clock != nil {
+		return g.clock.Now()
+	}
+	return time.Now()
+}
+
+// SetErrorTTL makes the group remember a failed local load of a key
+// for ttl, during which Gets for that key fail immediately with the
+// same error instead of calling the Getter again. A successful load
+// of the key forgets the error. At most maxCachedErrors errors are
+// remembered. A ttl of zero, the default, disables error caching.
+// It must be called before the group is used.
+func (g *Group) SetErrorTTL(ttl time.Duration) {
+	g.errorTTL = ttl
+}
+
+// refreshJitter is the largest fraction by which a key's refresh-ahead
+// point is moved earlier, so that keys loaded together are not all
+// refreshed together. The amount is derived from a hash of the key
+// rather than drawn at random, so that every hit on a value agrees on
+// whether it is due.
+const refreshJitter = 0.1
+
+// SetRefreshAhead makes a cache hit on a value older than fraction of
+// its lifetime refresh that value in the background, so that
+// popular keys are reloaded before they expire instead of all at once
+// when they do. Each key's refresh point is moved earlier by up to
+// 10%, by a fixed amount per key taken from a hash of the key rather
+// than a random one, and concurrent refreshes of a key are
+// deduplicated.
+// A fraction outside (0, 1), the default being 0, disables
+// refresh-ahead. It has no effect unless a TTL is set with SetTTL.
+// A value's lifetime is the TTL, or less if the Getter gave an earlier
+// expiry with SetBytesWithExpiry.
+// It must be called before the group is used.
+func (g *Group) SetRefreshAhead(fraction float64) {
+	if fraction <= 0 || fraction >= 1 {
+		fraction = 0
+	}
+	g.refreshAhead = fraction
+}
+
 func (g *Group) initPeers() {
 	if g.peers == nil {
 		g.peers = getPeers(g.name) //拿到HTTPPool
@@ -214,12 +703,21 @@ func (g *Group) Get(ctx Context, key string, dest Sink) error {
 	if dest == nil {
 		return errors.New("groupcache: nil dest Sink")
 	}
+	if err := g.checkKey(key); err != nil {
+		return err
+	}
 	value, cacheHit := g.lookupCache(key) //在缓存中查看是否有，包括mainCache和hotCache.第一次肯定是找不到的,第一次必须从磁盘拿到。
 
 	if cacheHit { //是否命中
 		g.Stats.CacheHits.Add(1)
+		g.maybeRefresh(ctx, key, value)
 		return setSinkView(dest, value)
 	}
+	if g.errorTTL > 0 {
+		if err := g.errCache.get(key, g.now()); err != nil {
+			return g.serveStale(key, dest, err)
+		}
+	}
 
 	// Optimization to avoid double unmarshalling or copying: keep
 	// track of whether the dest was already populated. One caller
@@ -228,7 +726,7 @@ func (g *Group) Get(ctx Context, key string, dest Sink) error {
 	destPopulated := false
 	value, destPopulated, err := g.load(ctx, key, dest) //如果没有在缓存中找到数据，就从getter方法中load进来,就是NewGroup的第三个方法。
 	if err != nil {
-		return err
+		return g.serveStale(key, dest, err)
 	}
 	if destPopulated { //若dest已经被填充
 		return nil
@@ -236,6 +734,147 @@ func (g *Group) Get(ctx Context, key string, dest Sink) error {
 	return setSinkView(dest, value)
 }
 
+// A StaleError is returned by Get, with dest populated with an expired
+// value, when the group has ServeStaleOnError set and a fresh value
+// could not be obtained. Err is the reason.
+type StaleError struct {
+	Err error
+}
+
+func (e *StaleError) Error() string {
+	return "groupcache: serving stale value: " + e.Err.Error()
+}
+
+// Unwrap returns the error that prevented a fresh value.
+func (e *StaleError) Unwrap() error { return e.Err }
+
+// serveStale handles a failure, err, to get a fresh value for key. If
+// the group serves stale values and still holds an expired one, it
+// populates dest with it and returns a *StaleError. Otherwise it
+// returns err.
+func (g *Group) serveStale(key string, dest Sink, err error) error {
+	if !g.ServeStaleOnError {
+		return err
+	}
+	value, ok := g.mainCache.peekAny(key)
+	if !ok {
+		value, ok = g.hotCache.peekAny(key)
+	}
+	if !ok {
+		return err
+	}
+	if serr := setSinkView(dest, value); serr != nil {
+		return serr
+	}
+	return &StaleError{Err: err}
+}
+
+// GetView is like Get, but returns the value as a ByteView instead of
+// writing it to a Sink. A cached value is returned without copying:
+// the view shares the cache's memory, which is fine since a ByteView
+// is immutable, but callers must not modify the bytes they get from
+// it with BytesNoCopy.
+func (g *Group) GetView(ctx Context, key string) (ByteView, error) {
+	var value ByteView
+	err := g.Get(ctx, key, ByteViewSink(&value))
+	return value, err
+}
+
+// IsLoading reports whether a load of key, from a peer or the
+// Getter, is in flight in this process, for example to skip a
+// redundant prefetch. It does not wait for the load.
+func (g *Group) IsLoading(key string) bool {
+	if pg, ok := g.loadGroup.(pendingFlightGroup); ok {
+		return pg.Pending(key)
+	}
+	return false
+}
+
+// Owner reports which peer owns key, without fetching it. If the
+// group's PeerPicker is an OwnerPicker, such as an HTTPPool, peerURL
+// names the owner, or this process if isLocal is set. Otherwise
+// peerURL is empty and only isLocal is meaningful. With no peers,
+// every key is local.
+func (g *Group) Owner(key string) (peerURL string, isLocal bool) {
+	g.peersOnce.Do(g.initPeers)
+	if op, ok := g.peers.(OwnerPicker); ok {
+		return op.Owner(g.peerKey(key))
+	}
+	_, remote := g.peers.PickPeer(g.peerKey(key))
+	return "", !remote
+}
+
+// GetRefresh is like Get, but ignores any value cached in this
+// process: it drops the key from the local caches and loads it again,
+// caching the fresh value. Concurrent Gets of the key share the
+// reload. Only local caches are bypassed; if another peer owns key,
+// that peer answers with the value it has.
+func (g *Group) GetRefresh(ctx Context, key string, dest Sink) error {
+	g.peersOnce.Do(g.initPeers)
+	g.Stats.Gets.Add(1)
+	if dest == nil {
+		return errors.New("groupcache: nil dest Sink")
+	}
+	if err := g.checkKey(key); err != nil {
+		return err
+	}
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	value, destPopulated, err := g.load(ctx, key, dest)
+	if err != nil {
+		return err
+	}
+	if destPopulated {
+		return nil
+	}
+	return setSinkView(dest, value)
+}
+
+// GetCacheOnly is like Get, but never loads the value with the
+// group's Getter. It consults the local caches and then, if another
+// peer owns key, that peer. It returns false, nil if the value is
+// owned by this process but not cached here.
+//
+// Note that the owning peer serves the request with a regular Get,
+// so it may load the value itself.
+func (g *Group) GetCacheOnly(ctx Context, key string, dest Sink) (bool, error) {
+	g.peersOnce.Do(g.initPeers)
+	g.Stats.Gets.Add(1)
+	if dest == nil {
+		return false, errors.New("groupcache: nil dest Sink")
+	}
+	if err := g.checkKey(key); err != nil {
+		return false, err
+	}
+	if value, cacheHit := g.lookupCache(key); cacheHit {
+		g.Stats.CacheHits.Add(1)
+		return true, setSinkView(dest, value)
+	}
+	peer, ok := g.peers.PickPeer(g.peerKey(key))
+	if !ok {
+		return false, nil
+	}
+	value, err := g.getFromPeer(ctx, peer, key)
+	if err != nil {
+		g.Stats.PeerErrors.Add(1)
+		g.logPeerError(key, err)
+		return false, err
+	}
+	g.Stats.PeerLoads.Add(1)
+	return true, setSinkView(dest, value)
+}
+
+// WriteTo resolves the value for key as Get does and writes it to w
+// without copying it into an intermediate buffer. It returns the
+// number of bytes written.
+func (g *Group) WriteTo(ctx Context, key string, w io.Writer) (int64, error) {
+	var value ByteView
+	if err := g.Get(ctx, key, ByteViewSink(&value)); err != nil {
+		return 0, err
+	}
+	return value.WriteTo(w)
+}
+
 // load loads key either by invoking the getter locally or by sending it to another machine.
 // 获取数据，从本地或者其它机器
 func (g *Group) load(ctx Context, key string, dest Sink) (value ByteView, destPopulated bool, err error) {
@@ -243,7 +882,10 @@ func (g *Group) load(ctx Context, key string, dest Sink) (value ByteView, destPo
 	//loadGroup减少对底层的调用，上面已经说了
 	//哈哈，调用的是singleflight.Group的Do方法，不是orderFlightGroup的。注意groupcache中的Group和singleflight中的Group不一样。
 	//这个loadGroup在前面创建Group的时候只是初始化为0值
-	viewi, err := g.loadGroup.Do(key, func() (interface{}, error) {
+	_, isContext := ctx.(context.Context)
+	// With a LoadTimeout, the load may outlive its callers too.
+	shared := g.timesOut() || isContext && g.CancelAbandonedLoads
+	viewi, err := g.doLoad(ctx, key, shared, func(ctx Context) (interface{}, error) {
 		// Check the cache again because singleflight can only dedup calls
 		// that overlap concurrently.  It's possible for 2 concurrent
 		// requests to miss the cache, resulting in 2 load() calls.  An
@@ -270,28 +912,53 @@ func (g *Group) load(ctx Context, key string, dest Sink) (value ByteView, destPo
 			return value, nil
 		}
 		g.Stats.LoadsDeduped.Add(1)
+		if value, ok := g.mainCache.unspill(key); ok {
+			g.populateCache(key, value, &g.mainCache)
+			return value, nil
+		}
 		var value ByteView
 		var err error
-		if peer, ok := g.peers.PickPeer(key); ok { //如果能从远程获取，就从分布式的其他机子获取，因为其他机器也是缓存数据比数据库快.其实就是HTTPPool的PickPeer函数。
+		var owner ProtoGetter
+		if peer, ok := g.peers.PickPeer(g.peerKey(key)); ok { //如果能从远程获取，就从分布式的其他机子获取，因为其他机器也是缓存数据比数据库快.其实就是HTTPPool的PickPeer函数。
+			if g.PeerFallbackMode == PeerFallbackHedged {
+				return g.hedgedLoad(ctx, peer, key, shared)
+			}
 			value, err = g.getFromPeer(ctx, peer, key) //第二个参数是httpGetter类型
+			var decodeErr *PeerDecodeError
+			if err != nil && g.RetryReplicaOnDecodeError && errors.As(err, &decodeErr) {
+				if replica, ok := g.nextReplica(key, peer); ok {
+					g.Stats.PeerErrors.Add(1)
+					g.logPeerError(key, err)
+					value, err = g.getFromPeer(ctx, replica, key)
+				}
+			}
 			if err == nil {
 				g.Stats.PeerLoads.Add(1)
+				g.forgetError(key)
 				return value, nil
 			}
 			g.Stats.PeerErrors.Add(1)
-			// TODO(bradfitz): log the peer's error? keep
-			// log of the past few for /groupcachez?  It's
-			// probably boring (normal task movement), so not
-			// worth logging I imagine.
+			g.logPeerError(key, err)
+			owner = peer
+		}
+		sink := dest
+		if shared {
+			// The caller may be gone by the time the Getter writes.
+			sink = ByteViewSink(new(ByteView))
 		}
-		value, err = g.getLocally(ctx, key, dest) //调用getter方法，获取数据(从数据库，或者其他地方)
+		value, abandoned, err := g.loadLocally(ctx, key, sink, shared)
 		if err != nil {
-			g.Stats.LocalLoadErrs.Add(1)
 			return nil, err
 		}
-		g.Stats.LocalLoads.Add(1)
-		destPopulated = true                      // only one caller of load gets this return value
-		g.populateCache(key, value, &g.mainCache) //把数据存放在cache中
+		if abandoned {
+			return value, nil
+		}
+		if !shared {
+			destPopulated = true // only one caller of load gets this return value
+		}
+		if pusher, ok := owner.(Pusher); ok && g.PushToOwnerOnLoad {
+			go g.pushToOwner(detach(ctx), pusher, key, value)
+		}
 		return value, nil
 	})
 	if err == nil {
@@ -300,101 +967,789 @@ func (g *Group) load(ctx Context, key string, dest Sink) (value ByteView, destPo
 	return
 }
 
-func (g *Group) getLocally(ctx Context, key string, dest Sink) (ByteView, error) {
-	err := g.getter.Get(ctx, key, dest)
+// loadLocally loads key with the group's Getter into dest and caches
+// the value. With shared set, ctx is the load's own context (see
+// CancelAbandonedLoads), and if it was canceled while loading, the
+// result is reported as abandoned and neither cached nor remembered
+// as an error.
+func (g *Group) loadLocally(ctx Context, key string, dest Sink, shared bool) (value ByteView, abandoned bool, err error) {
+	if err = g.loadLimit.take(ctx, g.now()); err != nil {
+		return ByteView{}, false, err
+	}
+	probe, err := g.breaker.allow(g.now())
 	if err != nil {
-		return ByteView{}, err
+		return ByteView{}, false, err
 	}
-	return dest.view()
-}
-
-// 从其它机器获取数据.每一个分布式的服务都需要实现一个Get方法，接口描述文件在proto文件中
-func (g *Group) getFromPeer(ctx Context, peer ProtoGetter, key string) (ByteView, error) {
-	req := &pb.GetRequest{
-		Group: &g.name,
-		Key:   &key,
+	if probe {
+		// Let another probe through if this one panics or is
+		// abandoned without a verdict.
+		defer g.breaker.endProbe()
+	}
+	value, err = g.getLocally(ctx, key, dest) //调用getter方法，获取数据(从数据库，或者其他地方)
+	abandoned = shared && ctx.(context.Context).Err() != nil
+	if !abandoned && !isContextErr(err) {
+		// A canceled load says nothing about the backend.
+		g.breaker.record(g.now(), probe, err)
 	}
-	res := &pb.GetResponse{}
-	err := peer.Get(ctx, req, res) //从远端得到数据
 	if err != nil {
-		return ByteView{}, err
+		g.Stats.LocalLoadErrs.Add(1)
+		g.logLoadError(key, err)
+		if g.errorTTL > 0 && !abandoned {
+			g.errCache.add(key, err, g.now().Add(g.errorTTL))
+		}
+		return ByteView{}, abandoned, err
 	}
-	value := ByteView{b: res.Value}
-	// TODO(bradfitz): use res.MinuteQps or something smart to
-	// conditionally populate hotCache.  For now just do it some
-	// percentage of the time.
-	if rand.Intn(10) == 0 { //哈哈，这里随机放在hotCache中,有意思
-		g.populateCache(key, value, &g.hotCache)
+	g.Stats.LocalLoads.Add(1)
+	if abandoned {
+		return value, true, nil
 	}
-	return value, nil
+	g.forgetError(key)
+	g.populateCache(key, value, &g.mainCache) //把数据存放在cache中
+	return value, false, nil
 }
 
-//这个方法比较简单，从是从maincache和hotcache中读取数据
-func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
-	if g.cacheBytes <= 0 {
-		return
+// isContextErr reports whether err is from a canceled or expired
+// context.
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// PeerFallbackMode is how a Group combines fetching a key from the
+// peer that owns it with loading the key itself.
+type PeerFallbackMode int
+
+const (
+	// PeerFallbackSerial loads the key locally only after the
+	// owner failed to return it. This is the default.
+	PeerFallbackSerial PeerFallbackMode = iota
+
+	// PeerFallbackHedged starts a local load as well if the owner
+	// has not answered within the group's HedgeDelay, and uses
+	// whichever value arrives first. It trades duplicate loads
+	// for latency when peers are slow.
+	PeerFallbackHedged
+)
+
+// hedgedLoad fetches key from peer, racing a local load against it
+// once HedgeDelay has passed or the peer has failed.
+func (g *Group) hedgedLoad(ctx Context, peer ProtoGetter, key string, shared bool) (interface{}, error) {
+	type result struct {
+		value ByteView
+		err   error
+		local bool
 	}
-	//语法：没有显式初始化的结构体变量都会自动初始化为相应类型的零值，下面mainCache，虽然在前面没有被显式初始化，但是是可以调用get方法的。
-	value, ok = g.mainCache.get(key)
-	if ok {
-		return
+	results := make(chan result, 2)
+	go func() {
+		value, err := g.getFromPeer(ctx, peer, key)
+		results <- result{value, err, false}
+	}()
+	pending := 1
+	timer := time.NewTimer(g.HedgeDelay)
+	defer timer.Stop()
+	hedge := timer.C
+	startLocal := func() {
+		pending++
+		hedge = nil
+		go func() {
+			// The loser keeps running after we return, so it
+			// must not write to the caller's Sink.
+			value, _, err := g.loadLocally(ctx, key, ByteViewSink(new(ByteView)), shared)
+			results <- result{value, err, true}
+		}()
 	}
-	value, ok = g.hotCache.get(key)
-	return
+	var err error
+	for pending > 0 {
+		select {
+		case <-hedge:
+			startLocal()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if !r.local {
+					g.Stats.PeerLoads.Add(1)
+					g.forgetError(key)
+				}
+				return r.value, nil
+			}
+			err = r.err
+			if !r.local {
+				g.Stats.PeerErrors.Add(1)
+				g.logPeerError(key, r.err)
+				if hedge != nil {
+					startLocal()
+				}
+			}
+		}
+	}
+	return nil, err
 }
 
-func (g *Group) populateCache(key string, value ByteView, cache *cache) {
-	if g.cacheBytes <= 0 {
-		return
+// peerKey returns the key by which the group's PeerPicker locates
+// the owner of key.
+func (g *Group) peerKey(key string) string {
+	if gk, ok := g.peers.(GroupKeyer); ok {
+		return gk.PeerKey(g.name, key)
 	}
-	cache.add(key, value)
+	return key
+}
 
-	// Evict items from cache(s) if necessary.
-	for {
-		mainBytes := g.mainCache.bytes()
-		hotBytes := g.hotCache.bytes()
-		if mainBytes+hotBytes <= g.cacheBytes {
-			return
+// nextReplica returns the remote replica owner of key that follows
+// peer, if the group's PeerPicker can nominate one.
+func (g *Group) nextReplica(key string, peer ProtoGetter) (ProtoGetter, bool) {
+	rp, ok := g.peers.(ReplicaPicker)
+	if !ok {
+		return nil, false
+	}
+	replicas, _ := rp.PickPeers(g.peerKey(key), 2)
+	for _, r := range replicas {
+		if r != peer {
+			return r, true
 		}
+	}
+	return nil, false
+}
 
-		// TODO(bradfitz): this is good-enough-for-now logic.
-		// It should be something based on measurements and/or
-		// respecting the costs of different resources.
-		victim := &g.mainCache
-		if hotBytes > mainBytes/8 {
-			victim = &g.hotCache
-		}
-		victim.removeOldest()
+// pushToOwner stores value in the cache of key's owner.
+func (g *Group) pushToOwner(ctx Context, owner Pusher, key string, value ByteView) {
+	if err := owner.Put(ctx, g.name, key, value.ByteSlice()); err != nil {
+		g.logPeerError(key, err)
 	}
 }
 
-// CacheType represents a type of cache.
-type CacheType int
+// timesOut reports whether loads are bounded by LoadTimeout.
+func (g *Group) timesOut() bool {
+	_, ok := g.loadGroup.(timeoutFlightGroup)
+	return ok && g.LoadTimeout > 0
+}
 
-const (
-	// The MainCache is the cache for items that this peer is the
-	// owner for.
-	MainCache CacheType = iota + 1
+// doLoad runs fn through loadGroup. If ctx is a context.Context, a
+// caller waiting for another caller's load of the same key returns
+// ctx.Err() once ctx is done. fn is passed ctx, unless shared is set,
+// in which case it runs with a context of its own that is canceled
+// once all callers have given up; see CancelAbandonedLoads. With a
+// LoadTimeout, callers instead wait at most that long, and fn's
+// context is canceled once it has run that long.
+func (g *Group) doLoad(ctx Context, key string, shared bool, fn func(Context) (interface{}, error)) (interface{}, error) {
+	if g.timesOut() {
+		tg := g.loadGroup.(timeoutFlightGroup)
+		return tg.DoWithTimeout(key, func() (interface{}, error) {
+			parent, ok := ctx.(context.Context)
+			if !ok {
+				parent = context.Background()
+			}
+			c, cancel := context.WithTimeout(parent, g.LoadTimeout)
+			defer cancel()
+			return fn(c)
+		}, g.LoadTimeout)
+	}
+	if cctx, ok := ctx.(context.Context); ok {
+		if sg, ok := g.loadGroup.(sharedFlightGroup); ok && shared {
+			return sg.DoShared(cctx, key, func(c context.Context) (interface{}, error) {
+				return fn(c)
+			})
+		}
+		if cg, ok := g.loadGroup.(contextFlightGroup); ok {
+			return cg.DoContext(cctx, key, func() (interface{}, error) { return fn(ctx) })
+		}
+	}
+	return g.loadGroup.Do(key, func() (interface{}, error) { return fn(ctx) })
+}
 
-	// The HotCache is the cache for items that seem popular
-	// enough to replicate to this node, even though it's not the
-	// owner.
-	HotCache
-)
+// maybeRefresh starts a background refresh of key if its cached value
+// has passed its refresh-ahead point.
+func (g *Group) maybeRefresh(ctx Context, key string, value ByteView) {
+	if g.dueForRefresh(key, value) {
+		// The refresh outlives the request that triggered it.
+		go g.refresh(detach(ctx), key)
+	}
+}
 
-// CacheStats returns stats about the provided cache within the group.
-func (g *Group) CacheStats(which CacheType) CacheStats {
-	switch which {
-	case MainCache:
-		return g.mainCache.stats()
-	case HotCache:
-		return g.hotCache.stats()
-	default:
-		return CacheStats{}
+// dueForRefresh reports whether the cached value for key has passed
+// its refresh-ahead point.
+func (g *Group) dueForRefresh(key string, value ByteView) bool {
+	if g.refreshAhead == 0 || g.ttl <= 0 || value.e.IsZero() || value.m.IsZero() {
+		return false
 	}
+	// Measure against the value's own lifetime, which is shorter than
+	// the TTL if the Getter gave an earlier expiry.
+	age := g.now().Sub(value.m)
+	lifetime := value.e.Sub(value.m)
+	jitter := float64(crc32.ChecksumIEEE([]byte(key))) / (1 << 32) * refreshJitter
+	return age >= time.Duration(float64(lifetime)*g.refreshAhead*(1-jitter))
 }
 
-// cache is a wrapper around an *lru.Cache that adds synchronization,
+// refresh reloads key, bypassing the cache, and stores the result in
+// the cache the value would have been loaded into. Failures are
+// ignored; the old value then expires as usual.
+func (g *Group) refresh(ctx Context, key string) {
+	g.refreshGroup.Do(key, func() (interface{}, error) {
+		// As in load, check again: a refresh that has just
+		// finished leaves a fresh value behind.
+		if value, cacheHit := g.lookupCache(key); cacheHit && !g.dueForRefresh(key, value) {
+			return value, nil
+		}
+		if peer, ok := g.peers.PickPeer(g.peerKey(key)); ok {
+			value, _, err := g.fetchFromPeer(ctx, peer, key)
+			if err != nil {
+				g.Stats.PeerErrors.Add(1)
+				g.logPeerError(key, err)
+				return nil, err
+			}
+			g.Stats.PeerLoads.Add(1)
+			if !g.disableHotCache {
+				g.populateCache(key, value, &g.hotCache)
+			}
+			return value, nil
+		}
+		value, _, err := g.loadLocally(ctx, key, ByteViewSink(new(ByteView)), false)
+		return value, err
+	})
+}
+
+// detachedContext carries the values of its parent, but is never
+// done, so that work started for a request can outlive it.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// detach returns ctx without its cancellation, if it is a
+// context.Context, or ctx unchanged otherwise.
+func detach(ctx Context) Context {
+	if c, ok := ctx.(context.Context); ok {
+		return detachedContext{c}
+	}
+	return ctx
+}
+
+// forgetError drops any cached load error for key.
+func (g *Group) forgetError(key string) {
+	if g.errorTTL > 0 {
+		g.errCache.remove(key)
+	}
+}
+
+func (g *Group) logPeerError(key string, err error) {
+	if g.Logger != nil {
+		g.Logger.PeerError(key, err)
+	}
+}
+
+func (g *Group) logLoadError(key string, err error) {
+	if g.Logger != nil {
+		g.Logger.LoadError(key, err)
+	}
+}
+
+func (g *Group) getLocally(ctx Context, key string, dest Sink) (ByteView, error) {
+	if g.OnLoadComplete != nil {
+		start := time.Now()
+		defer func() { g.OnLoadComplete(key, LoadSourceLocal, time.Since(start)) }()
+	}
+	g.getterMu.RLock()
+	getter := g.getter
+	g.getterMu.RUnlock()
+	if rg, ok := getter.(ReaderGetter); ok {
+		return g.getLocallyReader(ctx, rg, key, dest)
+	}
+	err := getter.Get(ctx, key, dest)
+	if err != nil {
+		return ByteView{}, err
+	}
+	return dest.view()
+}
+
+// ErrValueTooLarge is returned by Get when a ReaderGetter's value is
+// too big to fit in the group's cache, and by CompareAndSet for a
+// value that is.
+var ErrValueTooLarge = errors.New("groupcache: value too large for cache")
+
+// maxReaderPrealloc bounds the buffer allocated up front, on the word
+// of the ReaderGetter's size alone, for a group without a cache.
+const maxReaderPrealloc = 1 << 20
+
+// getLocallyReader reads the value for key from rg straight into a
+// buffer owned by the returned ByteView, then populates dest from it.
+// If the group has a cache, values that cannot fit in it are refused
+// before more than that many bytes are read.
+func (g *Group) getLocallyReader(ctx Context, rg ReaderGetter, key string, dest Sink) (ByteView, error) {
+	r, size, err := rg.GetReader(ctx, key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	defer r.Close()
+	limit := int64(-1)
+	if g.cacheBytes > 0 {
+		limit = g.cacheBytes - int64(len(key))
+	}
+	if limit >= 0 && size > limit {
+		return ByteView{}, ErrValueTooLarge
+	}
+	var b []byte
+	if size >= 0 && (limit >= 0 || size <= maxReaderPrealloc) {
+		b = make([]byte, size)
+		_, err = io.ReadFull(r, b)
+	} else {
+		// Grow as the data arrives rather than trusting size.
+		src := io.Reader(r)
+		if size >= 0 {
+			src = io.LimitReader(r, size)
+		} else if limit >= 0 {
+			src = io.LimitReader(r, limit+1)
+		}
+		b, err = ioutil.ReadAll(src)
+		if err == nil && limit >= 0 && int64(len(b)) > limit {
+			err = ErrValueTooLarge
+		} else if err == nil && size >= 0 && int64(len(b)) < size {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		return ByteView{}, err
+	}
+	value := ByteView{b: b}
+	if err := setSinkView(dest, value); err != nil {
+		return ByteView{}, err
+	}
+	return value, nil
+}
+
+// 从其它机器获取数据.每一个分布式的服务都需要实现一个Get方法，接口描述文件在proto文件中
+func (g *Group) getFromPeer(ctx Context, peer ProtoGetter, key string) (ByteView, error) {
+	if g.OnLoadComplete != nil {
+		start := time.Now()
+		defer func() { g.OnLoadComplete(key, LoadSourcePeer, time.Since(start)) }()
+	}
+	value, hint, err := g.fetchFromPeer(ctx, peer, key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	// Keys reported as hot are always mirrored and keys reported as
+	// not hot never are. Without a hint, populate hotCache some
+	// percentage of the time.
+	promote := hint == hotYes || hint == hotUnknown && rand.Intn(10) == 0
+	if !g.disableHotCache && !noHotCachePromotion(ctx) && promote { //哈哈，这里随机放在hotCache中,有意思
+		g.populateCache(key, value, &g.hotCache)
+	}
+	return value, nil
+}
+
+// noHotCachePromotionKey is the context key set by NoHotCachePromotion.
+type noHotCachePromotionKey struct{}
+
+// NoHotCachePromotion returns a copy of ctx that, passed to Get,
+// keeps values fetched from other peers for the request out of the
+// hotCache, for example for one-off scans that would otherwise evict
+// hot values. When concurrent Gets of a key share a fetch, the
+// Context of the first one applies.
+func NoHotCachePromotion(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noHotCachePromotionKey{}, true)
+}
+
+// noHotCachePromotion reports whether ctx was made by
+// NoHotCachePromotion.
+func noHotCachePromotion(ctx Context) bool {
+	c, ok := ctx.(context.Context)
+	return ok && c.Value(noHotCachePromotionKey{}) != nil
+}
+
+// hotHint is whether a peer's response asks for the value to be
+// mirrored in hotCache.
+type hotHint int
+
+const (
+	hotUnknown hotHint = iota // no hint; promote at random
+	hotYes
+	hotNo
+)
+
+// fetchFromPeer asks peer for the value of key. The value expires
+// when the peer says it should, and the hint says whether to mirror
+// it, from the peer's hint and the request rate it reported.
+func (g *Group) fetchFromPeer(ctx Context, peer ProtoGetter, key string) (ByteView, hotHint, error) {
+	req := getRequestPool.Get().(*pb.GetRequest)
+	req.Group = &g.name
+	req.Key = &key
+	res := getResponsePool.Get().(*pb.GetResponse)
+	defer func() {
+		// The returned ByteView keeps res.Value, and Reset only
+		// drops the message's reference to it.
+		req.Reset()
+		res.Reset()
+		getRequestPool.Put(req)
+		getResponsePool.Put(res)
+	}()
+	err := peer.Get(ctx, req, res) //从远端得到数据
+	if err != nil {
+		return ByteView{}, hotUnknown, err
+	}
+	value := ByteView{b: res.Value, gen: res.GetGeneration()}
+	if ms := res.GetTtlMs(); ms > 0 {
+		value.e = g.now().Add(time.Duration(ms) * time.Millisecond)
+	}
+	hint := hotUnknown
+	if res.Hot != nil {
+		hint = hotNo
+		if res.GetHot() {
+			hint = hotYes
+		}
+	}
+	if g.hotKeyQPS > 0 && res.GetMinuteQps() >= g.hotKeyQPS {
+		hint = hotYes
+	}
+	return value, hint, nil
+}
+
+// getRequestPool and getResponsePool recycle the messages of peer
+// fetches, which are allocated for every remote Get otherwise.
+var (
+	getRequestPool = sync.Pool{
+		New: func() interface{} { return new(pb.GetRequest) },
+	}
+	getResponsePool = sync.Pool{
+		New: func() interface{} { return new(pb.GetResponse) },
+	}
+)
+
+//这个方法比较简单，从是从maincache和hotcache中读取数据
+func (g *Group) lookupCache(key string) (value ByteView, ok bool) {
+	if g.cacheBytes <= 0 {
+		return
+	}
+	if g.MaxIdle > 0 {
+		cutoff := g.now().Add(-g.MaxIdle)
+		g.mainCache.removeIdle(cutoff)
+		g.hotCache.removeIdle(cutoff)
+	}
+	//语法：没有显式初始化的结构体变量都会自动初始化为相应类型的零值，下面mainCache，虽然在前面没有被显式初始化，但是是可以调用get方法的。
+	value, ok = g.mainCache.get(key, g.ServeStaleOnError)
+	if ok || g.disableHotCache {
+		return
+	}
+	value, ok = g.hotCache.get(key, g.ServeStaleOnError)
+	if ok && g.GenerationFunc != nil && value.gen < g.GenerationFunc(key) {
+		g.hotCache.remove(key)
+		return ByteView{}, false
+	}
+	return
+}
+
+func (g *Group) populateCache(key string, value ByteView, cache *cache) {
+	limit := g.cacheBytes
+	if g.separateBudgets {
+		limit = g.mainCacheBytes
+		if cache == &g.hotCache {
+			limit = g.hotCacheBytes
+		}
+	}
+	if limit <= 0 {
+		return
+	}
+	if g.CacheableFunc != nil && !g.CacheableFunc(key) {
+		return
+	}
+	if int64(len(key)+value.Len()) > limit {
+		// It would only evict everything else, then itself.
+		return
+	}
+	now := g.now()
+	if value.expired(now) {
+		return
+	}
+	if g.ttl > 0 {
+		if e := now.Add(g.ttl); value.e.IsZero() || e.Before(value.e) {
+			value.e = e
+		}
+	}
+	value.m = now
+	cache.add(key, value)
+	g.trimCaches()
+	trimGlobal()
+}
+
+// trimCaches evicts items from the caches until they fit in cacheBytes,
+// or in their own budgets if the group has separate budgets.
+func (g *Group) trimCaches() {
+	if g.separateBudgets {
+		for g.mainCache.bytes() > g.mainCacheBytes {
+			g.mainCache.removeOldest()
+		}
+		for g.hotCache.bytes() > g.hotCacheBytes {
+			g.hotCache.removeOldest()
+		}
+		return
+	}
+	for {
+		mainBytes := g.mainCache.bytes()
+		hotBytes := g.hotCache.bytes()
+		if mainBytes+hotBytes <= g.cacheBytes {
+			return
+		}
+		g.evictOne(mainBytes, hotBytes)
+	}
+}
+
+// evictOne removes the oldest item from whichever of the group's
+// caches should shrink, given their current sizes.
+func (g *Group) evictOne(mainBytes, hotBytes int64) {
+	// TODO(bradfitz): this is good-enough-for-now logic.
+	// It should be something based on measurements and/or
+	// respecting the costs of different resources.
+	victim := &g.mainCache
+	if g.evictionPolicy != nil {
+		if g.evictionPolicy(mainBytes, hotBytes) == HotCache {
+			victim = &g.hotCache
+		}
+	} else if hotBytes > mainBytes/8 {
+		victim = &g.hotCache
+	}
+	// Never pick an empty cache, or the caller would loop forever.
+	if victim == &g.mainCache && mainBytes == 0 {
+		victim = &g.hotCache
+	} else if victim == &g.hotCache && hotBytes == 0 {
+		victim = &g.mainCache
+	}
+	victim.removeOldest()
+}
+
+// SetEvictionPolicy replaces the heuristic that chooses whether to
+// evict from the mainCache or the hotCache when the group's caches
+// together exceed its cacheBytes. policy is given their current sizes
+// and returns MainCache or HotCache; an empty cache is never chosen.
+// A nil policy restores the default, which evicts from the hotCache
+// once it holds more than an eighth as many bytes as the mainCache.
+// It has no effect on a group with separate budgets (NewGroupOpts).
+// It must be called before the group is used.
+func (g *Group) SetEvictionPolicy(policy func(mainBytes, hotBytes int64) CacheType) {
+	g.evictionPolicy = policy
+}
+
+// globalCacheLimit is the limit set by SetGlobalCacheLimit.
+// It is accessed atomically.
+var globalCacheLimit int64
+
+// SetGlobalCacheLimit limits the total number of bytes cached by all
+// groups in this process, in addition to each group's own cacheBytes.
+// When the total exceeds the limit, items are evicted from the group
+// with the largest footprint until it fits. A limit of 0 or less
+// removes the global limit, which is the default.
+//
+// With a limit set, every cache fill inspects all registered groups,
+// so it is best suited to processes with a modest number of groups.
+func SetGlobalCacheLimit(bytes int64) {
+	atomic.StoreInt64(&globalCacheLimit, bytes)
+	trimGlobal()
+}
+
+// trimGlobal evicts items until all groups fit in globalCacheLimit.
+func trimGlobal() {
+	limit := atomic.LoadInt64(&globalCacheLimit)
+	if limit <= 0 {
+		return
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for {
+		var total, largestMain, largestHot int64
+		var largest *Group
+		for _, g := range groups {
+			mainBytes, hotBytes := g.mainCache.bytes(), g.hotCache.bytes()
+			total += mainBytes + hotBytes
+			if largest == nil || mainBytes+hotBytes > largestMain+largestHot {
+				largest, largestMain, largestHot = g, mainBytes, hotBytes
+			}
+		}
+		if total <= limit || largest == nil {
+			return
+		}
+		largest.evictOne(largestMain, largestHot)
+	}
+}
+
+// ErrCachingDisabled is returned by CompareAndSet on a group created
+// with a cacheBytes of zero or less, which caches nothing.
+var ErrCachingDisabled = errors.New("groupcache: caching is disabled for this group")
+
+// CompareAndSet replaces the locally cached value for key with
+// newValue, but only if the current value equals expected. It reports
+// whether the value was replaced; it returns false if the key is not
+// cached in this process or holds a different value. Neither peers
+// nor the Getter are consulted. A newValue that cannot fit in the
+// group's cache fails with ErrValueTooLarge.
+func (g *Group) CompareAndSet(key string, expected, newValue []byte) (bool, error) {
+	if err := g.checkKey(key); err != nil {
+		return false, err
+	}
+	if g.cacheBytes <= 0 {
+		return false, ErrCachingDisabled
+	}
+	if int64(len(key)+len(newValue)) > g.cacheBytes {
+		return false, ErrValueTooLarge
+	}
+	now := g.now()
+	value := ByteView{b: cloneBytes(newValue), m: now}
+	if g.ttl > 0 {
+		value.e = now.Add(g.ttl)
+	}
+	if !g.mainCache.compareAndSet(key, expected, value) &&
+		!g.hotCache.compareAndSet(key, expected, value) {
+		return false, nil
+	}
+	g.trimCaches()
+	trimGlobal()
+	return true, nil
+}
+
+// CacheType represents a type of cache.
+type CacheType int
+
+const (
+	// The MainCache is the cache for items that this peer is the
+	// owner for.
+	MainCache CacheType = iota + 1
+
+	// The HotCache is the cache for items that seem popular
+	// enough to replicate to this node, even though it's not the
+	// owner.
+	HotCache
+)
+
+// CacheStats returns stats about the provided cache within the group.
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	default:
+		return CacheStats{}
+	}
+}
+
+// GetFromCache returns the value for key held in the provided cache
+// within the group, if any. It never loads the value or asks a peer,
+// does not change the key's recency, and is not counted in the
+// group's stats. It is meant for diagnostics and tests.
+func (g *Group) GetFromCache(key string, which CacheType) (ByteView, bool) {
+	switch which {
+	case MainCache:
+		return g.mainCache.peek(key)
+	case HotCache:
+		return g.hotCache.peek(key)
+	default:
+		return ByteView{}, false
+	}
+}
+
+// LastModified returns when the unexpired value for key held in the
+// group's caches was loaded, for example to serve a Last-Modified
+// header. It reports false if no such value is cached.
+func (g *Group) LastModified(key string) (time.Time, bool) {
+	value, ok := g.mainCache.peek(key)
+	if !ok {
+		value, ok = g.hotCache.peek(key)
+	}
+	if !ok || value.m.IsZero() {
+		return time.Time{}, false
+	}
+	return value.m, true
+}
+
+// Clear removes all items from the provided cache within the group.
+func (g *Group) Clear(which CacheType) {
+	switch which {
+	case MainCache:
+		g.mainCache.clear()
+	case HotCache:
+		g.hotCache.clear()
+	}
+}
+
+// ClearAll removes all items from both of the group's caches.
+func (g *Group) ClearAll() {
+	g.mainCache.clear()
+	g.hotCache.clear()
+}
+
+// RemoveByPrefix removes every key that starts with prefix from both
+// of the group's caches, and returns the number of entries removed.
+// It scans all cached keys.
+func (g *Group) RemoveByPrefix(prefix string) int {
+	return g.mainCache.removeByPrefix(prefix) + g.hotCache.removeByPrefix(prefix)
+}
+
+// rebalanceGroups calls RemoveUnowned on the groups with
+// EvictOnRebalance set, and ReclassifyHotCache on those with
+// ReclassifyHotOnRebalance set, that use peers, whose membership just
+// changed.
+func rebalanceGroups(peers PeerPicker) {
+	mu.RLock()
+	var affected []*Group
+	for _, g := range groups {
+		if g.EvictOnRebalance || g.ReclassifyHotOnRebalance {
+			affected = append(affected, g)
+		}
+	}
+	mu.RUnlock()
+	for _, g := range affected {
+		g.peersOnce.Do(g.initPeers)
+		if g.peers != peers {
+			continue
+		}
+		if g.EvictOnRebalance {
+			g.RemoveUnowned()
+		}
+		if g.ReclassifyHotOnRebalance {
+			g.ReclassifyHotCache()
+		}
+	}
+}
+
+// RemoveUnowned removes from mainCache every key that the group's
+// PeerPicker now assigns to another peer, and returns the number of
+// entries removed. It is meant to be called after the peer set
+// changes, for instance from HTTPPool.OnRebalance.
+func (g *Group) RemoveUnowned() int {
+	g.peersOnce.Do(g.initPeers)
+	n := 0
+	for _, key := range g.mainCache.keys() {
+		if _, remote := g.peers.PickPeer(g.peerKey(key)); remote && g.mainCache.remove(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// ReclassifyHotCache sorts the hotCache out after the peer set
+// changes: values of keys the group's PeerPicker now assigns to this
+// process move to the mainCache, where owned keys belong, and the
+// rest are dropped, since their owner may have changed too. It
+// returns how many values were moved and dropped.
+func (g *Group) ReclassifyHotCache() (moved, dropped int) {
+	g.peersOnce.Do(g.initPeers)
+	for _, key := range g.hotCache.keys() {
+		value, ok := g.hotCache.peek(key)
+		if !g.hotCache.remove(key) {
+			continue
+		}
+		if _, remote := g.peers.PickPeer(g.peerKey(key)); remote || !ok {
+			dropped++
+			continue
+		}
+		g.mainCache.add(key, value)
+		moved++
+	}
+	if moved > 0 {
+		g.trimCaches()
+	}
+	return moved, dropped
+}
+
+// cache is a wrapper around an *lru.Cache that adds synchronization,
 // makes values always be ByteView, and counts the size of all keys and
 // values.
 //groupcache中的cache主要是加了并发安全，并添加一些统计数据, 一些操作都是直接调用lru.Cache,显然cache由lru.Cache组合而来.
@@ -405,11 +1760,181 @@ type cache struct {
 	lru        *lru.Cache
 	nhit, nget int64
 	nevict     int64 // number of evictions
+
+	// store, if non-nil, holds the bytes of the cached values, and
+	// lru holds a storedValue per key instead of the ByteView.
+	store ValueStore
+
+	// impl, if non-nil, replaces lru and store; see SetCacheFactory.
+	impl Cache
+
+	// capacity is the number of entries to preallocate room for
+	// when lru is created.
+	capacity int
+
+	// spill, if non-nil, receives the values lru evicts for lack of
+	// room; see SetSpillStore.
+	spill SpillStore
+
+	// clock, if non-nil, replaces time.Now; see SetClock.
+	clock Clock
+
+	// trackIdle makes lru record use times for removeIdle. It is
+	// set by the first removeIdle.
+	trackIdle bool
 }
 
-func (c *cache) stats() CacheStats {
+func (c *cache) now() time.Time {
+	if c.clock != nil {
+		return c.clock.Now()
+	}
+	return time.Now()
+}
+
+// A Cache is a replacement for the LRU that backs one of a Group's
+// caches, installed with SetCacheFactory. The Group still counts
+// gets and hits, applies TTLs and decides when to evict; the Cache
+// stores values and chooses which to evict. Calls are serialized by
+// the Group, so a Cache need not be safe for concurrent use.
+type Cache interface {
+	// Get returns the value for key.
+	Get(key string) (value ByteView, ok bool)
+
+	// Add stores value for key, replacing any previous value.
+	Add(key string, value ByteView)
+
+	// Remove removes key, reporting whether it was present.
+	Remove(key string) bool
+
+	// RemoveOldest evicts the entry the Cache considers least
+	// valuable. It must remove an entry if the Cache is not empty,
+	// since the Group calls it until Bytes fits the budget.
+	RemoveOldest()
+
+	// Keys returns the keys held by the Cache.
+	Keys() []string
+
+	// Bytes returns the total size of the keys and values held.
+	Bytes() int64
+
+	// Stats returns the Cache's statistics. Gets and Hits are
+	// counted by the Group and need not be filled in.
+	Stats() CacheStats
+}
+
+// SetCacheFactory makes the group back its mainCache and hotCache
+// with the Caches returned by newCache instead of the default LRU.
+// Stores set with SetValueStores are not used by such Caches.
+// It must be called before the group is used.
+func (g *Group) SetCacheFactory(newCache func(which CacheType) Cache) {
+	g.mainCache.impl = newCache(MainCache)
+	g.hotCache.impl = newCache(HotCache)
+}
+
+// A ValueStore holds the bytes of cached values on behalf of a Group's
+// cache, for instance in an off-heap arena or an mmap'd region, to
+// reduce garbage collector pressure for very large caches. The cache
+// still tracks keys, recency and sizes itself, and tells the store
+// when a value is evicted.
+//
+// A ValueStore must be safe for concurrent use. The slice returned by
+// Get is handed to callers as the value's ByteView, so it must not be
+// modified afterwards, and must stay valid even after the key is
+// deleted; a store that reuses memory must return a copy.
+type ValueStore interface {
+	// Put stores value for key. The store must copy value if it
+	// retains it.
+	Put(key string, value []byte)
+
+	// Get returns the value stored for key.
+	Get(key string) (value []byte, ok bool)
+
+	// Delete removes the value stored for key.
+	Delete(key string)
+}
+
+// A SpillStore is a second, larger tier behind a Group's mainCache,
+// such as a directory on local disk. Values evicted from the
+// mainCache to make room are put in the SpillStore, and a Get that
+// misses the mainCache looks there before loading the value. A value
+// read back moves into the mainCache and is deleted from the store.
+//
+// A SpillStore must be safe for concurrent use, and must copy the
+// value given to Put if it retains it.
+type SpillStore interface {
+	// Put stores value for key.
+	Put(key string, value []byte)
+
+	// Get returns the value stored for key.
+	Get(key string) (value []byte, ok bool)
+
+	// Delete removes the value stored for key.
+	Delete(key string)
+}
+
+// SetSpillStore makes the group spill values evicted from its
+// mainCache to s. Values with an expiry time (see SetTTL) are not
+// spilled, since s does not keep it. GetRefresh deletes the key from
+// s too, but other removals, such as RemoveByPrefix, only affect
+// memory. It has no effect on a cache installed with SetCacheFactory.
+// It must be called before the group is used.
+func (g *Group) SetSpillStore(s SpillStore) {
+	g.mainCache.spill = s
+}
+
+// storedValue is what the lru holds for a value kept in a ValueStore.
+type storedValue struct {
+	n   int       // length of the value
+	e   time.Time // expiry, as in ByteView
+	gen uint64    // generation, as in ByteView
+	m   time.Time // time added, as in ByteView
+}
+
+// SetValueStores makes the group keep the bytes of its mainCache and
+// hotCache values in the provided stores. A nil store keeps that
+// cache's values on the Go heap, which is the default.
+// It must be called before the group is used.
+func (g *Group) SetValueStores(main, hot ValueStore) {
+	g.mainCache.store = main
+	g.hotCache.store = hot
+}
+
+// SetInitialCapacity preallocates room for the given number of
+// entries in the mainCache and hotCache, which avoids repeatedly
+// growing their indexes as a large cache fills up.
+// It must be called before the group is used.
+func (g *Group) SetInitialCapacity(main, hot int) {
+	g.mainCache.capacity = main
+	g.hotCache.capacity = hot
+}
+
+// valueSize returns the length of the value held by a cache's lru.
+func valueSize(value interface{}) int64 {
+	if sv, ok := value.(storedValue); ok {
+		return int64(sv.n)
+	}
+	return int64(value.(ByteView).Len())
+}
+
+// rlock read-locks c and returns the matching unlock. A Cache
+// installed with SetCacheFactory need not allow concurrent reads, so
+// c is locked outright if it has one.
+func (c *cache) rlock() (unlock func()) {
+	if c.impl != nil {
+		c.mu.Lock()
+		return c.mu.Unlock
+	}
 	c.mu.RLock()
-	defer c.mu.RUnlock()
+	return c.mu.RUnlock
+}
+
+func (c *cache) stats() CacheStats {
+	defer c.rlock()()
+	if c.impl != nil {
+		st := c.impl.Stats()
+		st.Gets, st.Hits = c.nget, c.nhit
+		return st
+	}
 	return CacheStats{
 		Bytes:     c.nbytes,
 		Items:     c.itemsLocked(),
@@ -423,23 +1948,94 @@ func (c *cache) stats() CacheStats {
 func (c *cache) add(key string, value ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.addLocked(key, value)
+}
+
+func (c *cache) addLocked(key string, value ByteView) {
+	if c.impl != nil {
+		c.impl.Add(key, value)
+		return
+	}
 	if c.lru == nil {
-		c.lru = &lru.Cache{ // 设置lru中的淘汰函数
-			OnEvicted: func(key lru.Key, value interface{}) {
-				val := value.(ByteView)
-				c.nbytes -= int64(len(key.(string))) + int64(val.Len())
+		c.lru = lru.NewWithCapacity(0, c.capacity)
+		c.lru.TrackIdle = c.trackIdle
+		c.lru.Now = c.now
+		c.lru.OnEvictedReason = func(key lru.Key, value interface{}, reason lru.EvictReason) { // 设置lru中的淘汰函数
+			if c.spill != nil && reason == lru.ReasonCapacity {
+				c.spillLocked(key.(string), value)
+			}
+			c.nbytes -= int64(len(key.(string))) + valueSize(value)
+			if c.store != nil {
+				c.store.Delete(key.(string))
+			}
+			if reason == lru.ReasonCapacity || reason == lru.ReasonExpired || reason == lru.ReasonIdle {
 				c.nevict++
-			},
+			}
 		}
 	}
-	c.lru.Add(key, value)
+	// Remove any previous value first so that its size is
+	// subtracted from nbytes by OnEvictedReason.
+	c.lru.Remove(key)
+	if c.store != nil {
+		c.store.Put(key, value.BytesNoCopy())
+		c.lru.Add(key, storedValue{n: value.Len(), e: value.e, gen: value.gen, m: value.m})
+	} else {
+		c.lru.Add(key, value)
+	}
 	c.nbytes += int64(len(key)) + int64(value.Len())
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
+// spillLocked puts the value the lru holds for key in c.spill, unless
+// it has an expiry time.
+func (c *cache) spillLocked(key string, vi interface{}) {
+	value, ok := c.resolve(key, vi)
+	if ok && value.e.IsZero() {
+		c.spill.Put(key, value.BytesNoCopy())
+	}
+}
+
+// unspill takes the value for key out of c.spill.
+func (c *cache) unspill(key string) (ByteView, bool) {
+	if c.spill == nil {
+		return ByteView{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.spill.Get(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	c.spill.Delete(key)
+	return ByteView{b: b}, true
+}
+
+// get returns the unexpired value for key. Expired values are
+// dropped, unless keepExpired is set.
+func (c *cache) get(key string, keepExpired bool) (value ByteView, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.nget++
+	value, ok = c.getLocked(key, keepExpired)
+	if ok {
+		c.nhit++
+	}
+	return
+}
+
+// getLocked returns the unexpired value for key, dropping it if it
+// has expired unless keepExpired is set. It does not update the hit
+// counters.
+func (c *cache) getLocked(key string, keepExpired bool) (value ByteView, ok bool) {
+	if c.impl != nil {
+		value, ok = c.impl.Get(key)
+		if ok && value.expired(c.now()) {
+			if !keepExpired {
+				c.impl.Remove(key)
+			}
+			return ByteView{}, false
+		}
+		return
+	}
 	if c.lru == nil {
 		return
 	}
@@ -447,37 +2043,300 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 	if !ok {
 		return
 	}
-	c.nhit++
-	return vi.(ByteView), true
+	value, ok = c.resolve(key, vi)
+	if !ok {
+		// The store lost the value; forget the key too.
+		c.lru.Remove(key)
+		return ByteView{}, false
+	}
+	if value.expired(c.now()) {
+		if !keepExpired {
+			c.lru.Expire(key)
+		}
+		return ByteView{}, false
+	}
+	return value, true
+}
+
+// resolve returns the value for key given what the lru holds for it,
+// fetching the bytes from the store if needed. It reports false if
+// the store no longer has them.
+func (c *cache) resolve(key string, vi interface{}) (ByteView, bool) {
+	sv, isStored := vi.(storedValue)
+	if !isStored {
+		return vi.(ByteView), true
+	}
+	b, found := c.store.Get(key)
+	if !found || len(b) != sv.n {
+		return ByteView{}, false
+	}
+	return ByteView{b: b, e: sv.e, gen: sv.gen, m: sv.m}, true
+}
+
+// peek returns the unexpired value for key without updating its
+// recency or the hit counters.
+func (c *cache) peek(key string) (value ByteView, ok bool) {
+	value, ok = c.peekAny(key)
+	if !ok || value.expired(c.now()) {
+		return ByteView{}, false
+	}
+	return value, true
+}
+
+// peekAny is like peek, but also returns expired values. A Cache
+// installed with SetCacheFactory is read with its Get method.
+func (c *cache) peekAny(key string) (value ByteView, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.impl != nil {
+		return c.impl.Get(key)
+	}
+	if c.lru == nil {
+		return
+	}
+	vi, ok := c.lru.Peek(key)
+	if !ok {
+		return
+	}
+	return c.resolve(key, vi)
+}
+
+// compareAndSet replaces the value for key with value if the current
+// value equals expected.
+func (c *cache) compareAndSet(key string, expected []byte, value ByteView) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, ok := c.getLocked(key, false)
+	if !ok || !old.EqualBytes(expected) {
+		return false
+	}
+	c.addLocked(key, value)
+	return true
 }
 
 func (c *cache) removeOldest() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru != nil {
+	if c.impl != nil {
+		c.impl.RemoveOldest()
+	} else if c.lru != nil {
 		c.lru.RemoveOldest()
 	}
 }
 
+// removeIdle removes the entries not used since cutoff and returns
+// how many it removed. A Cache installed with SetCacheFactory is left
+// alone.
+func (c *cache) removeIdle(cutoff time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.trackIdle {
+		// Recording use times costs a clock read per Get, so it
+		// only starts once Group.MaxIdle is in use.
+		c.trackIdle = true
+		if c.lru != nil {
+			c.lru.TrackIdle = true
+		}
+	}
+	return c.lru.RemoveIdle(cutoff)
+}
+
+// keys returns a snapshot of the cached keys.
+func (c *cache) keys() []string {
+	defer c.rlock()()
+	if c.impl != nil {
+		return c.impl.Keys()
+	}
+	if c.lru == nil {
+		return nil
+	}
+	lkeys := c.lru.Keys()
+	keys := make([]string, len(lkeys))
+	for i, key := range lkeys {
+		keys[i] = key.(string)
+	}
+	return keys
+}
+
+// remove removes key, reporting whether it was present.
+func (c *cache) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.impl != nil {
+		return c.impl.Remove(key)
+	}
+	if c.spill != nil {
+		c.spill.Delete(key)
+	}
+	if c.lru == nil || c.lru.Len() == 0 {
+		return false
+	}
+	n := c.lru.Len()
+	c.lru.Remove(key)
+	return c.lru.Len() < n
+}
+
+func (c *cache) removeByPrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.impl != nil {
+		n := 0
+		for _, key := range c.impl.Keys() {
+			if strings.HasPrefix(key, prefix) && c.impl.Remove(key) {
+				n++
+			}
+		}
+		return n
+	}
+	if c.lru == nil {
+		return 0
+	}
+	n := 0
+	for _, key := range c.lru.Keys() {
+		if strings.HasPrefix(key.(string), prefix) {
+			c.lru.Remove(key)
+			n++
+		}
+	}
+	return n
+}
+
+// clear purges all entries. The lru's OnEvictedReason callback
+// brings nbytes back down to zero.
+func (c *cache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.impl != nil {
+		for _, key := range c.impl.Keys() {
+			c.impl.Remove(key)
+		}
+	} else if c.lru != nil {
+		c.lru.Clear()
+	}
+}
+
 func (c *cache) bytes() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	defer c.rlock()()
+	if c.impl != nil {
+		return c.impl.Bytes()
+	}
 	return c.nbytes
 }
 
 func (c *cache) items() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	defer c.rlock()()
 	return c.itemsLocked()
 }
 
 func (c *cache) itemsLocked() int64 {
+	if c.impl != nil {
+		return c.impl.Stats().Items
+	}
 	if c.lru == nil {
 		return 0
 	}
 	return int64(c.lru.Len())
 }
 
+// maxCachedErrors bounds the number of load errors a Group remembers.
+const maxCachedErrors = 1024
+
+// errorCache remembers recent load errors by key, evicting the least
+// recently used beyond max, or maxCachedErrors if max is zero.
+type errorCache struct {
+	max int
+	mu  sync.Mutex
+	lru *lru.Cache
+}
+
+type cachedError struct {
+	err     error
+	expires time.Time
+}
+
+func (c *errorCache) add(key string, err error, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		max := c.max
+		if max <= 0 {
+			max = maxCachedErrors
+		}
+		c.lru = lru.New(max)
+	}
+	c.lru.Add(key, cachedError{err: err, expires: expires})
+}
+
+// get returns the error cached for key, if any, that has not expired
+// by now.
+func (c *errorCache) get(key string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	vi, ok := c.lru.Get(key)
+	if !ok {
+		return nil
+	}
+	ce := vi.(cachedError)
+	if now.After(ce.expires) {
+		c.lru.Remove(key)
+		return nil
+	}
+	return ce.err
+}
+
+func (c *errorCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru.Remove(key)
+	}
+}
+
+// maxTrackedKeys bounds the number of keys whose request rate a Group
+// tracks for SetHotKeyQPS.
+const maxTrackedKeys = 10000
+
+// rateWindow is the interval over which key request rates are measured.
+const rateWindow = time.Second
+
+// keyRates tracks the request rate of recently requested keys,
+// forgetting the least recently requested beyond maxTrackedKeys.
+type keyRates struct {
+	mu  sync.Mutex
+	lru *lru.Cache
+}
+
+type keyRate struct {
+	start time.Time // start of the current window
+	n     int64     // requests in the current window
+	qps   float64   // rate over the last complete window
+}
+
+// hit records a request for key at now and returns the key's rate
+// over the last complete window.
+func (r *keyRates) hit(key string, now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lru == nil {
+		r.lru = lru.New(maxTrackedKeys)
+	}
+	vi, ok := r.lru.Get(key)
+	if !ok {
+		vi = &keyRate{start: now}
+		r.lru.Add(key, vi)
+	}
+	kr := vi.(*keyRate)
+	kr.n++
+	if elapsed := now.Sub(kr.start); elapsed >= rateWindow {
+		kr.qps = float64(kr.n) / elapsed.Seconds()
+		kr.start, kr.n = now, 0
+	}
+	return kr.qps
+}
+
 // An AtomicInt is an int64 to be accessed atomically.
 type AtomicInt int64
 
@@ -486,6 +2345,17 @@ func (i *AtomicInt) Add(n int64) {
 	atomic.AddInt64((*int64)(i), n)
 }
 
+// Sub atomically subtracts n from i.
+func (i *AtomicInt) Sub(n int64) {
+	atomic.AddInt64((*int64)(i), -n)
+}
+
+// CompareAndSwap atomically sets i to new if it is old, and reports
+// whether it did.
+func (i *AtomicInt) CompareAndSwap(old, new int64) bool {
+	return atomic.CompareAndSwapInt64((*int64)(i), old, new)
+}
+
 // Get atomically gets the value of i.
 func (i *AtomicInt) Get() int64 {
 	return atomic.LoadInt64((*int64)(i))
@@ -497,9 +2367,9 @@ func (i *AtomicInt) String() string {
 
 // CacheStats are returned by stats accessors on Group.
 type CacheStats struct {
-	Bytes     int64
-	Items     int64
-	Gets      int64
-	Hits      int64
-	Evictions int64
+	Bytes     int64 `json:"bytes"`
+	Items     int64 `json:"items"`
+	Gets      int64 `json:"gets"`
+	Hits      int64 `json:"hits"`
+	Evictions int64 `json:"evictions"`
 }
@@ -19,12 +19,19 @@ limitations under the License.
 package groupcache
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
@@ -32,6 +39,7 @@ import (
 	"github.com/golang/protobuf/proto"
 
 	pb "groupcache/groupcachepb"
+	"groupcache/singleflight"
 	testpb "groupcache/testpb"
 )
 
@@ -340,6 +348,45 @@ func TestTruncatingByteSliceTarget(t *testing.T) {
 	}
 }
 
+func TestTruncatingByteSliceSinkN(t *testing.T) {
+	once.Do(testSetup)
+	var buf [100]byte
+	for _, tt := range []struct {
+		key       string
+		size      int
+		truncated int
+	}{
+		{"short", 100, 0},
+		{"truncated", 6, 8},
+	} {
+		s := buf[:tt.size]
+		truncated := -1
+		if err := stringGroup.Get(dummyCtx, tt.key, TruncatingByteSliceSinkN(&s, &truncated)); err != nil {
+			t.Fatal(err)
+		}
+		if truncated != tt.truncated {
+			t.Errorf("key %q truncated %d bytes; want %d", tt.key, truncated, tt.truncated)
+		}
+	}
+}
+
+func TestAppendingByteSliceSink(t *testing.T) {
+	once.Do(testSetup)
+	buf := []byte("<")
+	for _, key := range []string{"a", "b"} {
+		sink := AppendingByteSliceSink(&buf)
+		if err := stringGroup.Get(dummyCtx, key, sink); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := sink.view(); v.String() != "ECHO:"+key {
+			t.Errorf("view = %q; want the last value %q", v.String(), "ECHO:"+key)
+		}
+	}
+	if got, want := string(buf), "<ECHO:aECHO:b"; got != want {
+		t.Errorf("buffer = %q; want %q", got, want)
+	}
+}
+
 func TestAllocatingByteSliceTarget(t *testing.T) {
 	var dst []byte
 	sink := AllocatingByteSliceSink(&dst)
@@ -452,5 +499,1924 @@ func TestGroupStatsAlignment(t *testing.T) {
 	}
 }
 
-// TODO(bradfitz): port the Google-internal full integration test into here,
-// using HTTP requests instead of our RPC system.
+func TestGroupSnapshot(t *testing.T) {
+	g := newGroup("TestGroupSnapshot-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val:" + key)
+	}), nil)
+	for i := 0; i < 3; i++ {
+		var s string
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap := g.Snapshot()
+	if snap.Gets != 3 || snap.CacheHits != 2 || snap.LocalLoads != 1 {
+		t.Errorf("Snapshot = %+v; want 3 gets, 2 cache hits, 1 local load", snap)
+	}
+	if snap.MainCache.Items != 1 {
+		t.Errorf("MainCache.Items = %d; want 1", snap.MainCache.Items)
+	}
+}
+
+func TestDisableHotCache(t *testing.T) {
+	peer := &fakePeer{}
+	g := newGroup("TestDisableHotCache-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("got:" + key)
+	}), fakePeers{peer})
+	g.DisableHotCache()
+	for i := 0; i < 100; i++ {
+		var s string
+		if err := g.Get(dummyCtx, fmt.Sprintf("key-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if items := g.CacheStats(HotCache).Items; items != 0 {
+		t.Errorf("hotCache has %d items; want 0", items)
+	}
+	if peer.hits != 100 {
+		t.Errorf("peer hits = %d; want 100", peer.hits)
+	}
+}
+
+func TestCacheableFunc(t *testing.T) {
+	var fills int
+	g := newGroup("TestCacheableFunc-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		fills++
+		return dest.SetString("val:" + key)
+	}), nil)
+	g.CacheableFunc = func(key string) bool {
+		return !strings.HasPrefix(key, "token:")
+	}
+	for _, key := range []string{"token:a", "token:a", "plain", "plain"} {
+		var s string
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		if want := "val:" + key; s != want {
+			t.Errorf("Get(%q) = %q; want %q", key, s, want)
+		}
+	}
+	if fills != 3 {
+		t.Errorf("fills = %d; want 3", fills)
+	}
+	if items := g.CacheStats(MainCache).Items; items != 1 {
+		t.Errorf("mainCache has %d items; want 1", items)
+	}
+}
+
+func TestGroupClear(t *testing.T) {
+	g := newGroup("TestGroupClear-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val:" + key)
+	}), nil)
+	for i := 0; i < 10; i++ {
+		var s string
+		if err := g.Get(dummyCtx, fmt.Sprintf("key-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if items := g.CacheStats(MainCache).Items; items != 10 {
+		t.Fatalf("mainCache has %d items; want 10", items)
+	}
+	g.Clear(MainCache)
+	st := g.CacheStats(MainCache)
+	if st.Items != 0 || st.Bytes != 0 {
+		t.Errorf("after Clear, mainCache has %d items and %d bytes; want 0 and 0", st.Items, st.Bytes)
+	}
+	if st.Evictions != 0 {
+		t.Errorf("Clear counted %d evictions; want 0", st.Evictions)
+	}
+}
+
+func TestLoadRateLimit(t *testing.T) {
+	g := newGroup("TestLoadRateLimit-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val:" + key)
+	}), nil)
+	get := func(key string) error {
+		var s string
+		return g.Get(dummyCtx, key, StringSink(&s))
+	}
+
+	g.SetLoadRateLimit(2, false)
+	for _, key := range []string{"a", "b"} {
+		if err := get(key); err != nil {
+			t.Fatalf("Get(%q) within burst: %v", key, err)
+		}
+	}
+	if err := get("c"); err != ErrLoadRateLimited {
+		t.Fatalf("Get over the limit = %v; want ErrLoadRateLimited", err)
+	}
+	if err := get("a"); err != nil {
+		t.Errorf("cached Get over the limit = %v; want nil", err)
+	}
+
+	g.SetLoadRateLimit(20, true)
+	start := time.Now()
+	for i := 0; i < 22; i++ {
+		if err := get(fmt.Sprintf("blocking-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("22 loads at 20/sec took %v; want at least 50ms", d)
+	}
+
+	g.SetLoadRateLimit(0, false)
+	for i := 0; i < 100; i++ {
+		if err := get(fmt.Sprintf("unlimited-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	var fills AtomicInt
+	g := newGroup("TestTTL-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		fills.Add(1)
+		return dest.SetString("val:" + key)
+	}), nil)
+	g.SetTTL(50 * time.Millisecond)
+	get := func() {
+		var s string
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get()
+	get()
+	if n := fills.Get(); n != 1 {
+		t.Fatalf("fills before expiry = %d; want 1", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	get()
+	if n := fills.Get(); n != 2 {
+		t.Errorf("fills after expiry = %d; want 2", n)
+	}
+	if st := g.CacheStats(MainCache); st.Items != 1 || st.Evictions != 1 {
+		t.Errorf("mainCache stats = %+v; want 1 item and 1 eviction", st)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var fills AtomicInt
+	g := newGroup("TestRefreshAhead-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		fills.Add(1)
+		return dest.SetString(fmt.Sprint(fills.Get()))
+	}), nil)
+	g.SetTTL(time.Second)
+	g.SetRefreshAhead(0.1)
+	get := func() string {
+		var s string
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if v := get(); v != "1" {
+		t.Fatalf("first Get = %q; want %q", v, "1")
+	}
+	time.Sleep(150 * time.Millisecond)
+	// This hit is past the refresh point; it returns the cached
+	// value and starts a refresh.
+	if v := get(); v != "1" {
+		t.Fatalf("Get past the refresh point = %q; want cached %q", v, "1")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for get() != "2" {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the refreshed value")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := fills.Get(); n != 2 {
+		t.Errorf("fills = %d; want 2", n)
+	}
+}
+
+func TestGroupWriteTo(t *testing.T) {
+	once.Do(testSetup)
+	var buf bytes.Buffer
+	n, err := stringGroup.(*Group).WriteTo(dummyCtx, "TestGroupWriteTo-key", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ECHO:TestGroupWriteTo-key"; buf.String() != want || n != int64(len(want)) {
+		t.Errorf("WriteTo wrote %q (n=%d); want %q", buf.String(), n, want)
+	}
+}
+
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *recordingLogger) PeerError(key string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, "peer:"+key)
+}
+
+func (l *recordingLogger) LoadError(key string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, "load:"+key)
+}
+
+func TestGroupLogger(t *testing.T) {
+	g := newGroup("TestGroupLogger-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("backend down")
+	}), fakePeers{&fakePeer{fail: true}})
+	logger := &recordingLogger{}
+	g.Logger = logger
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err == nil {
+		t.Fatal("Get succeeded; want error")
+	}
+	if got, want := fmt.Sprint(logger.events), "[peer:key load:key]"; got != want {
+		t.Errorf("logged %s; want %s", got, want)
+	}
+}
+
+func TestInProcessPool(t *testing.T) {
+	names := []string{"n0", "n1", "n2"}
+	members := make(map[string]*Group)
+	loads := make(map[string]*AtomicInt)
+	for _, name := range names {
+		name := name
+		n := new(AtomicInt)
+		loads[name] = n
+		pool := NewInProcessPool(name)
+		g := newGroup("TestInProcessPool-"+name, 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+			n.Add(1)
+			return dest.SetString(name + ":" + key)
+		}), pool)
+		members[name] = g
+	}
+	for _, g := range members {
+		g.peers.(*InProcessPool).Set(members)
+	}
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner := members["n0"].peers.(*InProcessPool).peers.Get(key)
+		for _, name := range names {
+			var s string
+			if err := members[name].Get(dummyCtx, key, StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+			if want := owner + ":" + key; s != want {
+				t.Errorf("%s: Get(%q) = %q; want %q", name, key, s, want)
+			}
+		}
+	}
+	var total int64
+	for _, n := range loads {
+		total += n.Get()
+	}
+	if total != 30 {
+		t.Errorf("total local loads = %d; want 30 (one per key)", total)
+	}
+}
+
+func TestErrorTTL(t *testing.T) {
+	var calls int
+	fail := true
+	g := newGroup("TestErrorTTL-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		calls++
+		if fail {
+			return errors.New("backend 500")
+		}
+		return dest.SetString("ok")
+	}), nil)
+	g.SetErrorTTL(50 * time.Millisecond)
+	get := func() error {
+		var s string
+		return g.Get(dummyCtx, "key", StringSink(&s))
+	}
+	for i := 0; i < 3; i++ {
+		if err := get(); err == nil {
+			t.Fatal("Get succeeded; want error")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("getter calls within error TTL = %d; want 1", calls)
+	}
+	time.Sleep(60 * time.Millisecond)
+	fail = false
+	if err := get(); err != nil {
+		t.Errorf("Get after error TTL = %v; want success", err)
+	}
+	if calls != 2 {
+		t.Errorf("getter calls after error TTL = %d; want 2", calls)
+	}
+}
+
+// mapStore is a ValueStore backed by a map, for tests.
+type mapStore struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (s *mapStore) Put(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string][]byte)
+	}
+	s.m[key] = cloneBytes(value)
+}
+
+func (s *mapStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.m[key]
+	return b, ok
+}
+
+func (s *mapStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+}
+
+func (s *mapStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.m)
+}
+
+func TestValueStore(t *testing.T) {
+	var fills int
+	const cacheBytes = 100
+	g := newGroup("TestValueStore-group", cacheBytes, GetterFunc(func(_ Context, key string, dest Sink) error {
+		fills++
+		return dest.SetString("val:" + key)
+	}), nil)
+	store := &mapStore{}
+	g.SetValueStores(store, nil)
+	for i := 0; i < 2; i++ {
+		var s string
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		if s != "val:key" {
+			t.Errorf("Get = %q; want %q", s, "val:key")
+		}
+	}
+	if fills != 1 || store.len() != 1 {
+		t.Errorf("fills = %d, store has %d values; want 1 and 1", fills, store.len())
+	}
+
+	// Overflow the cache; the store should track evictions.
+	for i := 0; i < 20; i++ {
+		var s string
+		if err := g.Get(dummyCtx, fmt.Sprintf("key-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st := g.CacheStats(MainCache)
+	if int64(store.len()) != st.Items || st.Bytes > cacheBytes {
+		t.Errorf("store has %d values, cache stats %+v", store.len(), st)
+	}
+}
+
+func TestRemoveByPrefix(t *testing.T) {
+	g := newGroup("TestRemoveByPrefix-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val:" + key)
+	}), nil)
+	for _, key := range []string{"user:1:a", "user:1:b", "user:12:a", "user:2:a"} {
+		var s string
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := g.RemoveByPrefix("user:1:"); n != 2 {
+		t.Errorf("RemoveByPrefix removed %d; want 2", n)
+	}
+	st := g.CacheStats(MainCache)
+	if want := int64(len("user:12:a") + len("val:user:12:a") + len("user:2:a") + len("val:user:2:a")); st.Items != 2 || st.Bytes != want {
+		t.Errorf("after RemoveByPrefix, stats = %+v; want 2 items, %d bytes", st, want)
+	}
+}
+
+// readerGetter implements both Getter and ReaderGetter.
+type readerGetter struct {
+	readerCalls int
+}
+
+func (g *readerGetter) Get(_ Context, key string, dest Sink) error {
+	return errors.New("Get called on a ReaderGetter")
+}
+
+func (g *readerGetter) GetReader(_ Context, key string) (io.ReadCloser, int64, error) {
+	g.readerCalls++
+	size := int64(len(key))
+	if key == "unsized" {
+		size = -1
+	}
+	return ioutil.NopCloser(strings.NewReader(key)), size, nil
+}
+
+func TestReaderGetter(t *testing.T) {
+	rg := &readerGetter{}
+	g := newGroup("TestReaderGetter-group", 1<<20, rg, nil)
+	for _, key := range []string{"sized", "unsized", "sized"} {
+		var b []byte
+		if err := g.Get(dummyCtx, key, AllocatingByteSliceSink(&b)); err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != key {
+			t.Errorf("Get(%q) = %q; want %q", key, b, key)
+		}
+	}
+	if rg.readerCalls != 2 {
+		t.Errorf("GetReader calls = %d; want 2", rg.readerCalls)
+	}
+}
+
+// sizedReaderGetter returns value for every key, reporting size.
+type sizedReaderGetter struct {
+	value string
+	size  int64
+}
+
+func (g sizedReaderGetter) Get(_ Context, key string, dest Sink) error {
+	return errors.New("Get called on a ReaderGetter")
+}
+
+func (g sizedReaderGetter) GetReader(_ Context, key string) (io.ReadCloser, int64, error) {
+	return ioutil.NopCloser(strings.NewReader(g.value)), g.size, nil
+}
+
+func TestReaderGetterLimits(t *testing.T) {
+	big := strings.Repeat("x", 100)
+	for i, tt := range []struct {
+		rg         sizedReaderGetter
+		cacheBytes int64
+		want       error
+	}{
+		{sizedReaderGetter{"abc", 1 << 62}, 64, ErrValueTooLarge},
+		{sizedReaderGetter{big, -1}, 64, ErrValueTooLarge},
+		{sizedReaderGetter{big, 100}, 64, ErrValueTooLarge},
+		{sizedReaderGetter{"abc", 1 << 62}, 0, io.ErrUnexpectedEOF},
+		{sizedReaderGetter{big, -1}, 0, nil},
+		{sizedReaderGetter{"abc", 3}, 64, nil},
+	} {
+		g := newGroup(fmt.Sprintf("TestReaderGetterLimits-%d", i), tt.cacheBytes, tt.rg, NoPeers{})
+		var s string
+		err := g.Get(dummyCtx, "key", StringSink(&s))
+		if err != tt.want {
+			t.Errorf("%d: Get = %v; want %v", i, err, tt.want)
+		}
+		if err == nil && s != tt.rg.value {
+			t.Errorf("%d: Get = %q; want %q", i, s, tt.rg.value)
+		}
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	g := newGroup("TestCompareAndSet-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v1")
+	}), nil)
+	if ok, err := g.CompareAndSet("key", []byte("v1"), []byte("v2")); ok || err != nil {
+		t.Errorf("CompareAndSet on absent key = %v, %v; want false, nil", ok, err)
+	}
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := g.CompareAndSet("key", []byte("other"), []byte("v2")); ok || err != nil {
+		t.Errorf("CompareAndSet with wrong expected value = %v, %v; want false, nil", ok, err)
+	}
+	if ok, err := g.CompareAndSet("key", []byte("v1"), []byte("v2-longer")); !ok || err != nil {
+		t.Errorf("CompareAndSet with matching value = %v, %v; want true, nil", ok, err)
+	}
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "v2-longer" {
+		t.Errorf("Get after CompareAndSet = %q, %v; want %q", s, err, "v2-longer")
+	}
+	if st := g.CacheStats(MainCache); st.Items != 1 || st.Bytes != int64(len("key")+len("v2-longer")) {
+		t.Errorf("cache stats after CompareAndSet = %+v", st)
+	}
+	if _, ok := g.LastModified("key"); !ok {
+		t.Error("LastModified after CompareAndSet reports the key as not cached")
+	}
+}
+
+func TestGetCacheOnly(t *testing.T) {
+	var fills int
+	peer := &fakePeer{}
+	peers := fakePeers{nil}
+	g := newGroup("TestGetCacheOnly-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		fills++
+		return dest.SetString("local:" + key)
+	}), peers)
+	var s string
+	if ok, err := g.GetCacheOnly(dummyCtx, "key", StringSink(&s)); ok || err != nil {
+		t.Errorf("GetCacheOnly of uncached owned key = %v, %v; want false, nil", ok, err)
+	}
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := g.GetCacheOnly(dummyCtx, "key", StringSink(&s)); !ok || err != nil || s != "local:key" {
+		t.Errorf("GetCacheOnly of cached key = %v, %v, %q; want true, nil, %q", ok, err, s, "local:key")
+	}
+	peers[0] = peer
+	if ok, err := g.GetCacheOnly(dummyCtx, "remote", StringSink(&s)); !ok || err != nil || s != "got:remote" {
+		t.Errorf("GetCacheOnly of peer-owned key = %v, %v, %q; want true, nil, %q", ok, err, s, "got:remote")
+	}
+	if fills != 1 {
+		t.Errorf("fills = %d; want 1", fills)
+	}
+}
+
+func TestGlobalCacheLimit(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("x", 90))
+	})
+	big := newGroup("TestGlobalCacheLimit-big", 1<<20, getter, NoPeers{})
+	small := newGroup("TestGlobalCacheLimit-small", 1<<20, getter, NoPeers{})
+	const limit = 3000
+	SetGlobalCacheLimit(limit)
+	defer SetGlobalCacheLimit(0)
+
+	var s string
+	for i := 0; i < 40; i++ {
+		if err := big.Get(dummyCtx, fmt.Sprintf("key-%02d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := small.Get(dummyCtx, fmt.Sprintf("key-%02d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bigBytes, smallBytes := big.CacheStats(MainCache).Bytes, small.CacheStats(MainCache).Bytes
+	if bigBytes+smallBytes > limit {
+		t.Errorf("groups hold %d bytes; want at most %d", bigBytes+smallBytes, limit)
+	}
+	if want := int64(3 * 96); smallBytes != want {
+		t.Errorf("small group holds %d bytes; want %d, evictions should come from the larger group", smallBytes, want)
+	}
+}
+
+// genPeer is a peer that reports gen as the generation of every value.
+type genPeer struct {
+	gen uint64
+}
+
+func (p *genPeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	out.Value = []byte(fmt.Sprintf("%s@%d", in.GetKey(), p.gen))
+	out.Generation = proto.Uint64(p.gen)
+	return nil
+}
+
+func TestGenerationFunc(t *testing.T) {
+	var gen uint64 = 1
+	peer := &genPeer{gen: gen}
+	g := newGroup("TestGenerationFunc-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), fakePeers{peer})
+	g.GenerationFunc = func(string) uint64 { return gen }
+
+	value, _, err := g.fetchFromPeer(dummyCtx, peer, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.gen != 1 {
+		t.Fatalf("fetched generation = %d; want 1", value.gen)
+	}
+	g.populateCache("key", value, &g.hotCache)
+	if v, ok := g.lookupCache("key"); !ok || v.String() != "key@1" {
+		t.Fatalf("lookupCache = %q, %v; want %q, true", v.String(), ok, "key@1")
+	}
+
+	gen, peer.gen = 2, 2
+	if _, ok := g.lookupCache("key"); ok {
+		t.Error("lookupCache served a hotCache value from an older generation")
+	}
+	if n := g.CacheStats(HotCache).Items; n != 0 {
+		t.Errorf("hotCache has %d items after stale lookup; want 0", n)
+	}
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "key@2" {
+		t.Errorf("Get = %q, %v; want %q, nil", s, err, "key@2")
+	}
+}
+
+func TestNewGroupOpts(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected load")
+	})
+	g := NewGroupOpts("TestNewGroupOpts-group", getter, &GroupOptions{
+		MainCacheBytes: 500,
+		HotCacheBytes:  300,
+	})
+	value := ByteView{s: strings.Repeat("x", 94)}
+	for i := 0; i < 10; i++ {
+		g.populateCache(fmt.Sprintf("main-%d", i), value, &g.mainCache)
+	}
+	for i := 0; i < 10; i++ {
+		g.populateCache(fmt.Sprintf("hotk-%d", i), value, &g.hotCache)
+	}
+	if st := g.CacheStats(MainCache); st.Items != 5 || st.Bytes != 500 {
+		t.Errorf("mainCache has %d items, %d bytes; want 5, 500", st.Items, st.Bytes)
+	}
+	if st := g.CacheStats(HotCache); st.Items != 3 || st.Bytes != 300 {
+		t.Errorf("hotCache has %d items, %d bytes; want 3, 300", st.Items, st.Bytes)
+	}
+
+	noHot := NewGroupOpts("TestNewGroupOpts-nohot", getter, &GroupOptions{MainCacheBytes: 500})
+	noHot.populateCache("hot", value, &noHot.hotCache)
+	if _, ok := noHot.lookupCache("hot"); ok {
+		t.Error("group with no HotCacheBytes cached a hot value")
+	}
+}
+
+func TestLoadContextCancel(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	g := newGroup("TestLoadContextCancel-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		close(started)
+		<-release
+		return dest.SetString("val")
+	}), NoPeers{})
+
+	leader := make(chan error)
+	go func() {
+		var s string
+		leader <- g.Get(context.Background(), "key", StringSink(&s))
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var s string
+	if err := g.Get(ctx, "key", StringSink(&s)); err != context.DeadlineExceeded {
+		t.Errorf("waiting Get = %v; want %v", err, context.DeadlineExceeded)
+	}
+	close(release)
+	if err := <-leader; err != nil {
+		t.Errorf("leading Get = %v", err)
+	}
+}
+
+func TestGetRefresh(t *testing.T) {
+	var version int
+	g := newGroup("TestGetRefresh-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(fmt.Sprintf("%s@%d", key, version))
+	}), NoPeers{})
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	version++
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "key@0" {
+		t.Fatalf("Get = %q, %v; want cached %q", s, err, "key@0")
+	}
+	if err := g.GetRefresh(dummyCtx, "key", StringSink(&s)); err != nil || s != "key@1" {
+		t.Fatalf("GetRefresh = %q, %v; want %q", s, err, "key@1")
+	}
+	version++
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "key@1" {
+		t.Errorf("Get after GetRefresh = %q, %v; want cached %q", s, err, "key@1")
+	}
+}
+
+// fifoCache is a Cache that evicts in insertion order.
+type fifoCache struct {
+	order  []string
+	values map[string]ByteView
+	nbytes int64
+	nevict int64
+}
+
+func newFIFOCache(CacheType) Cache {
+	return &fifoCache{values: make(map[string]ByteView)}
+}
+
+func (c *fifoCache) Get(key string) (ByteView, bool) {
+	v, ok := c.values[key]
+	return v, ok
+}
+
+func (c *fifoCache) Add(key string, value ByteView) {
+	c.Remove(key)
+	c.order = append(c.order, key)
+	c.values[key] = value
+	c.nbytes += int64(len(key) + value.Len())
+}
+
+func (c *fifoCache) Remove(key string) bool {
+	v, ok := c.values[key]
+	if !ok {
+		return false
+	}
+	delete(c.values, key)
+	c.nbytes -= int64(len(key) + v.Len())
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (c *fifoCache) RemoveOldest() {
+	if len(c.order) > 0 {
+		c.Remove(c.order[0])
+		c.nevict++
+	}
+}
+
+func (c *fifoCache) Keys() []string { return append([]string(nil), c.order...) }
+func (c *fifoCache) Bytes() int64   { return c.nbytes }
+
+func (c *fifoCache) Stats() CacheStats {
+	return CacheStats{Bytes: c.nbytes, Items: int64(len(c.values)), Evictions: c.nevict}
+}
+
+func TestCacheFactory(t *testing.T) {
+	g := newGroup("TestCacheFactory-group", 30, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val")
+	}), NoPeers{})
+	g.SetCacheFactory(newFIFOCache)
+	var s string
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5", "k6", "k7"} {
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading k2 does not protect it from eviction in FIFO order.
+	g.Get(dummyCtx, "k2", StringSink(&s))
+	g.Get(dummyCtx, "k8", StringSink(&s))
+	fifo := g.mainCache.impl.(*fifoCache)
+	if got, want := fmt.Sprint(fifo.Keys()), "[k3 k4 k5 k6 k7 k8]"; got != want {
+		t.Errorf("cached keys = %s; want %s", got, want)
+	}
+	st := g.CacheStats(MainCache)
+	if st.Items != 6 || st.Bytes != 30 || st.Evictions != 2 || st.Hits != 1 {
+		t.Errorf("CacheStats = %+v; want 6 items, 30 bytes, 2 evictions, 1 hit", st)
+	}
+}
+
+// countingCache is a fifoCache whose reads write too, so that the
+// race detector flags reads that are not serialized.
+type countingCache struct {
+	fifoCache
+	reads int
+}
+
+func (c *countingCache) Keys() []string    { c.reads++; return c.fifoCache.Keys() }
+func (c *countingCache) Bytes() int64      { c.reads++; return c.fifoCache.Bytes() }
+func (c *countingCache) Stats() CacheStats { c.reads++; return c.fifoCache.Stats() }
+
+func TestCacheFactoryReadsAreSerialized(t *testing.T) {
+	g := newGroup("TestCacheFactoryReadsAreSerialized-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val")
+	}), NoPeers{})
+	g.SetCacheFactory(func(CacheType) Cache {
+		return &countingCache{fifoCache: fifoCache{values: make(map[string]ByteView)}}
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				g.CacheStats(MainCache)
+				g.mainCache.keys()
+				g.mainCache.bytes()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := g.mainCache.impl.(*countingCache).reads; n != 4*100*3 {
+		t.Errorf("reads = %d; want %d", n, 4*100*3)
+	}
+}
+
+func TestCachingEnabled(t *testing.T) {
+	var loads int
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString("val")
+	})
+	off := newGroup("TestCachingEnabled-off", 0, getter, NoPeers{})
+	on := newGroup("TestCachingEnabled-on", 1<<20, getter, NoPeers{})
+	if off.CachingEnabled() || !on.CachingEnabled() {
+		t.Fatalf("CachingEnabled = %v, %v; want false, true", off.CachingEnabled(), on.CachingEnabled())
+	}
+	var s string
+	for i := 0; i < 2; i++ {
+		if err := off.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads != 2 {
+		t.Errorf("loads with caching disabled = %d; want 2", loads)
+	}
+}
+
+func TestSetBytesWithExpiry(t *testing.T) {
+	var loads int
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		loads++
+		if key == "short" {
+			return dest.SetBytesWithExpiry([]byte("v"), time.Now().Add(20*time.Millisecond))
+		}
+		return dest.SetString("v")
+	})
+	for _, g := range []*Group{
+		newGroup("TestSetBytesWithExpiry-group", 1<<20, getter, NoPeers{}),
+		newGroup("TestSetBytesWithExpiry-fallback", 1<<20, FallbackGetter(getter), NoPeers{}),
+	} {
+		loads = 0
+		var s string
+		for _, key := range []string{"short", "long", "short", "long"} {
+			if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if loads != 2 {
+			t.Errorf("%s: loads before expiry = %d; want 2", g.Name(), loads)
+		}
+		time.Sleep(30 * time.Millisecond)
+		for _, key := range []string{"short", "long"} {
+			if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if loads != 3 {
+			t.Errorf("%s: loads after expiry = %d; want 3", g.Name(), loads)
+		}
+	}
+}
+
+// qpsPeer is a peer that reports qps as the request rate of every key.
+type qpsPeer struct {
+	qps float64
+}
+
+func (p *qpsPeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	out.Value = []byte("got:" + in.GetKey())
+	out.MinuteQps = proto.Float64(p.qps)
+	return nil
+}
+
+func TestHotKeyQPS(t *testing.T) {
+	var r keyRates
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		r.hit("key", now.Add(time.Duration(i)*10*time.Millisecond))
+	}
+	if qps := r.hit("key", now.Add(time.Second)); qps < 45 || qps > 55 {
+		t.Errorf("rate after 51 requests in 1s = %v; want about 51", qps)
+	}
+
+	peer := &qpsPeer{qps: 100}
+	g := newGroup("TestHotKeyQPS-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), fakePeers{peer})
+	g.SetHotKeyQPS(50)
+	var s string
+	for i := 0; i < 5; i++ {
+		if err := g.Get(dummyCtx, fmt.Sprintf("hot-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := g.CacheStats(HotCache).Items; n != 5 {
+		t.Errorf("hotCache has %d of 5 hot keys", n)
+	}
+}
+
+func TestChecksumSink(t *testing.T) {
+	sum := sha256.Sum256([]byte("payload"))
+	var s string
+	if err := ChecksumSink(StringSink(&s), sum[:], sha256.New).SetString("payload"); err != nil || s != "payload" {
+		t.Errorf("matching value = %q, %v; want %q, nil", s, err, "payload")
+	}
+	s = ""
+	if err := ChecksumSink(StringSink(&s), sum[:], sha256.New).SetBytes([]byte("corrupt")); err != ErrChecksumMismatch {
+		t.Errorf("corrupt value error = %v; want ErrChecksumMismatch", err)
+	}
+	if s != "" {
+		t.Errorf("corrupt value reached the inner sink: %q", s)
+	}
+}
+
+func TestGetFromCache(t *testing.T) {
+	g := newGroup("TestGetFromCache-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val:" + key)
+	}), NoPeers{})
+	if _, ok := g.GetFromCache("key", MainCache); ok {
+		t.Fatal("GetFromCache found a value before any Get")
+	}
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	gets := g.CacheStats(MainCache).Gets
+	if v, ok := g.GetFromCache("key", MainCache); !ok || v.String() != "val:key" {
+		t.Errorf("GetFromCache(MainCache) = %q, %v; want %q, true", v.String(), ok, "val:key")
+	}
+	if _, ok := g.GetFromCache("key", HotCache); ok {
+		t.Error("GetFromCache(HotCache) found a locally owned key")
+	}
+	if n := g.CacheStats(MainCache).Gets; n != gets {
+		t.Errorf("GetFromCache changed Gets from %d to %d", gets, n)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int
+	fail := true
+	g := newGroup("TestCircuitBreaker-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		calls++
+		if fail {
+			return errors.New("backend down")
+		}
+		return dest.SetString("val")
+	}), NoPeers{})
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	g.SetClock(clock)
+	g.SetCircuitBreaker(3, time.Minute, 20*time.Millisecond)
+
+	var s string
+	for i := 0; i < 5; i++ {
+		g.Get(dummyCtx, fmt.Sprintf("key-%d", i), StringSink(&s))
+	}
+	if calls != 3 {
+		t.Errorf("getter calls with circuit open = %d; want 3", calls)
+	}
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != ErrCircuitOpen {
+		t.Errorf("Get with circuit open = %v; want ErrCircuitOpen", err)
+	}
+
+	// After the cooldown, a failing probe reopens the circuit.
+	clock.Advance(30 * time.Millisecond)
+	g.Get(dummyCtx, "probe-1", StringSink(&s))
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != ErrCircuitOpen || calls != 4 {
+		t.Errorf("after failed probe: Get = %v with %d calls; want ErrCircuitOpen with 4", err, calls)
+	}
+
+	// A successful probe closes it.
+	clock.Advance(30 * time.Millisecond)
+	fail = false
+	if err := g.Get(dummyCtx, "probe-2", StringSink(&s)); err != nil {
+		t.Fatalf("probe Get = %v", err)
+	}
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Errorf("Get after successful probe = %v", err)
+	}
+}
+
+func TestServeStaleOnError(t *testing.T) {
+	fail := false
+	g := newGroup("TestServeStaleOnError-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		if fail {
+			return errors.New("backend down")
+		}
+		return dest.SetString("val:" + key)
+	}), NoPeers{})
+	g.SetTTL(10 * time.Millisecond)
+	g.ServeStaleOnError = true
+
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	fail = true
+	s = ""
+	err := g.Get(dummyCtx, "key", StringSink(&s))
+	var se *StaleError
+	if !errors.As(err, &se) || s != "val:key" {
+		t.Fatalf("Get with failing getter = %q, %v; want stale %q with a StaleError", s, err, "val:key")
+	}
+	if err := g.Get(dummyCtx, "other", StringSink(&s)); errors.As(err, &se) || err == nil {
+		t.Errorf("Get of never-cached key = %v; want the load error", err)
+	}
+
+	fail = false
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Errorf("Get after recovery = %v", err)
+	}
+}
+
+func TestSetGetter(t *testing.T) {
+	g := newGroup("TestSetGetter-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("old:" + key)
+	}), NoPeers{})
+	var s string
+	if err := g.Get(dummyCtx, "a", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	g.SetGetter(GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("new:" + key)
+	}))
+	for key, want := range map[string]string{"a": "old:a", "b": "new:b"} {
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil || s != want {
+			t.Errorf("Get(%q) = %q, %v; want %q", key, s, err, want)
+		}
+	}
+}
+
+func TestOnLoadComplete(t *testing.T) {
+	peers := fakePeers{&fakePeer{}, nil}
+	g := newGroup("TestOnLoadComplete-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("local:" + key)
+	}), peers)
+	got := map[string]string{}
+	g.OnLoadComplete = func(key, source string, d time.Duration) {
+		if d < 0 {
+			t.Errorf("OnLoadComplete(%q) duration = %v", key, d)
+		}
+		got[key] = source
+	}
+	want := map[string]string{}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		want[key] = LoadSourceLocal
+		if _, ok := peers.PickPeer(key); ok {
+			want[key] = LoadSourcePeer
+		}
+		var s string
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reported sources = %v; want %v", got, want)
+	}
+}
+
+// pushPeer is a Pusher whose Get always fails.
+type pushPeer struct {
+	pushed chan string
+}
+
+func (p *pushPeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	return errors.New("simulated error from peer")
+}
+
+func (p *pushPeer) Put(_ Context, group, key string, value []byte) error {
+	p.pushed <- key + "=" + string(value)
+	return nil
+}
+
+func TestPushToOwnerOnLoad(t *testing.T) {
+	peer := &pushPeer{pushed: make(chan string, 1)}
+	g := newGroup("TestPushToOwnerOnLoad-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("local:" + key)
+	}), fakePeers{peer})
+	g.PushToOwnerOnLoad = true
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-peer.pushed:
+		if want := "key=local:key"; got != want {
+			t.Errorf("pushed %q; want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("value was not pushed to the owner")
+	}
+}
+
+func TestInspectingSink(t *testing.T) {
+	var methods []string
+	g := newGroup("TestInspectingSink-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		dest = InspectingSink(dest, func(method string) { methods = append(methods, method) })
+		if key == "proto" {
+			return dest.SetProto(&testpb.TestMessage{Name: proto.String(key)})
+		}
+		return dest.SetBytes([]byte(key))
+	}), NoPeers{})
+	for _, key := range []string{"bytes", "proto"} {
+		var v ByteView
+		if err := g.Get(dummyCtx, key, ByteViewSink(&v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := fmt.Sprint(methods), "[SetBytes SetProto]"; got != want {
+		t.Errorf("Set methods = %s; want %s", got, want)
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	g := newGroup("TestLastModified-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), NoPeers{})
+	if _, ok := g.LastModified("key"); ok {
+		t.Fatal("LastModified reported a value that is not cached")
+	}
+	before := time.Now()
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := g.LastModified("key")
+	if !ok || m.Before(before) || m.After(time.Now()) {
+		t.Errorf("LastModified = %v, %v; want a time since %v", m, ok, before)
+	}
+}
+
+// replicaPeers is a ReplicaPicker that nominates its peers, in order,
+// as the owners of every key.
+type replicaPeers []ProtoGetter
+
+func (p replicaPeers) PickPeer(key string) (ProtoGetter, bool) {
+	return p[0], true
+}
+
+func (p replicaPeers) PickPeers(key string, n int) ([]ProtoGetter, bool) {
+	if n > len(p) {
+		n = len(p)
+	}
+	return p[:n], true
+}
+
+// decodeErrorPeer answers every request with a *PeerDecodeError.
+type decodeErrorPeer struct{}
+
+func (decodeErrorPeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	return &PeerDecodeError{URL: "http://bad", Err: errors.New("truncated")}
+}
+
+func TestRetryReplicaOnDecodeError(t *testing.T) {
+	replica := &fakePeer{}
+	var loads int
+	g := newGroup("TestRetryReplicaOnDecodeError-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString("local:" + key)
+	}), replicaPeers{decodeErrorPeer{}, replica})
+	g.RetryReplicaOnDecodeError = true
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if s != "got:key" || replica.hits != 1 || loads != 0 {
+		t.Errorf("Get = %q with %d replica hits and %d local loads; want %q from the replica", s, replica.hits, loads, "got:key")
+	}
+}
+
+func TestNoHotCachePromotion(t *testing.T) {
+	g := newGroup("TestNoHotCachePromotion-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), fakePeers{&qpsPeer{qps: 100}})
+	g.SetHotKeyQPS(50)
+	ctx := NoHotCachePromotion(context.Background())
+	var s string
+	for i := 0; i < 5; i++ {
+		if err := g.Get(ctx, fmt.Sprintf("scan-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := g.CacheStats(HotCache).Items; n != 0 {
+		t.Errorf("hotCache has %d keys fetched with NoHotCachePromotion; want 0", n)
+	}
+	if err := g.Get(context.Background(), "hot", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if n := g.CacheStats(HotCache).Items; n != 1 {
+		t.Errorf("hotCache has %d keys; want 1", n)
+	}
+}
+
+// mapSpillStore is a SpillStore backed by a map.
+type mapSpillStore struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (s *mapSpillStore) Put(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[key] = append([]byte(nil), value...)
+}
+
+func (s *mapSpillStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.m[key]
+	return v, ok
+}
+
+func (s *mapSpillStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+}
+
+func TestSpillStore(t *testing.T) {
+	var loads int
+	g := newGroup("TestSpillStore-group", 100, GetterFunc(func(_ Context, key string, dest Sink) error {
+		loads++
+		return dest.SetString(strings.Repeat("x", 20) + key)
+	}), NoPeers{})
+	store := &mapSpillStore{m: make(map[string][]byte)}
+	g.SetSpillStore(store)
+	var s string
+	for i := 0; i < 10; i++ {
+		if err := g.Get(dummyCtx, fmt.Sprintf("key-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := store.Get("key-0"); !ok {
+		t.Fatal("evicted key-0 was not spilled")
+	}
+	if err := g.Get(dummyCtx, "key-0", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("x", 20) + "key-0"; s != want || loads != 10 {
+		t.Errorf("Get(key-0) = %q after %d loads; want %q after 10", s, loads, want)
+	}
+	if _, ok := store.Get("key-0"); ok {
+		t.Error("key-0 is still spilled after moving back to memory")
+	}
+}
+
+func TestOwner(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(key)
+	})
+	g := newGroup("TestOwner-nopeers-group", 1<<20, getter, NoPeers{})
+	if url, local := g.Owner("key"); url != "" || !local {
+		t.Errorf("Owner with no peers = %q, %v; want \"\", true", url, local)
+	}
+
+	p := newHTTPPool("http://a", nil)
+	p.Set("http://a", "http://b", "http://c")
+	g = newGroup("TestOwner-pool-group", 1<<20, getter, p)
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5"} {
+		want := p.peers.Get(key)
+		if url, local := g.Owner(key); url != want || local != (want == "http://a") {
+			t.Errorf("Owner(%q) = %q, %v; want %q", key, url, local, want)
+		}
+	}
+}
+
+func TestGetView(t *testing.T) {
+	g := newGroup("TestGetView-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetBytes([]byte("val:" + key))
+	}), NoPeers{})
+	v1, err := g.GetView(dummyCtx, "key")
+	if err != nil || v1.String() != "val:key" {
+		t.Fatalf("GetView = %q, %v; want %q, nil", v1.String(), err, "val:key")
+	}
+	v2, err := g.GetView(dummyCtx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &v1.BytesNoCopy()[0] != &v2.BytesNoCopy()[0] {
+		t.Error("GetView copied a cached value")
+	}
+}
+
+func TestCancelAbandonedLoads(t *testing.T) {
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	block := true
+	g := newGroup("TestCancelAbandonedLoads-group", 1<<20, GetterFunc(func(ctx Context, key string, dest Sink) error {
+		if block {
+			close(started)
+			<-ctx.(context.Context).Done()
+			close(stopped)
+			return ctx.(context.Context).Err()
+		}
+		return dest.SetString("loaded")
+	}), NoPeers{})
+	g.CancelAbandonedLoads = true
+	g.SetErrorTTL(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		var s string
+		errc <- g.Get(ctx, "key", StringSink(&s))
+	}()
+	<-started
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Get = %v; want %v", err, context.Canceled)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("abandoned load was not canceled")
+	}
+
+	// The abandoned load's error is not remembered.
+	block = false
+	var s string
+	if err := g.Get(context.Background(), "key", StringSink(&s)); err != nil || s != "loaded" {
+		t.Errorf("Get after abandoned load = %q, %v; want %q, nil", s, err, "loaded")
+	}
+}
+
+func TestSetEvictionPolicy(t *testing.T) {
+	peer := &fakePeer{}
+	g := newGroup("TestSetEvictionPolicy-group", 300, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(strings.Repeat("m", 20))
+	}), fakePeers{peer, nil})
+	g.SetEvictionPolicy(func(mainBytes, hotBytes int64) CacheType {
+		return MainCache
+	})
+	hot := ByteView{s: strings.Repeat("h", 20)}
+	for i := 0; i < 5; i++ {
+		g.populateCache(fmt.Sprintf("hot-%d", i), hot, &g.hotCache)
+	}
+	var s string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if _, remote := g.peers.PickPeer(key); remote {
+			continue
+		}
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := g.CacheStats(HotCache).Items; n != 5 {
+		t.Errorf("hotCache has %d items; want all 5 kept by the policy", n)
+	}
+}
+
+// slowPeer answers once release is closed.
+type slowPeer struct {
+	release chan struct{}
+}
+
+func (p *slowPeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	<-p.release
+	out.Value = []byte("peer:" + in.GetKey())
+	return nil
+}
+
+func TestPeerFallbackHedged(t *testing.T) {
+	peer := &slowPeer{release: make(chan struct{})}
+	defer close(peer.release)
+	g := newGroup("TestPeerFallbackHedged-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("local:" + key)
+	}), fakePeers{peer})
+	g.PeerFallbackMode = PeerFallbackHedged
+	g.HedgeDelay = 10 * time.Millisecond
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "local:key" {
+		t.Errorf("Get with a slow peer = %q, %v; want %q, nil", s, err, "local:key")
+	}
+
+	fast := newGroup("TestPeerFallbackHedged-fast-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		t.Error("unexpected local load")
+		return errors.New("unexpected local load")
+	}), fakePeers{&fakePeer{}})
+	fast.PeerFallbackMode = PeerFallbackHedged
+	fast.HedgeDelay = time.Hour
+	if err := fast.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "got:key" {
+		t.Errorf("Get with a fast peer = %q, %v; want %q, nil", s, err, "got:key")
+	}
+}
+
+// hintPeer is a peer that answers with a TTL and hot hint.
+type hintPeer struct {
+	ttlMs int64
+	hot   *bool
+	gets  int
+}
+
+func (p *hintPeer) Get(_ Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	p.gets++
+	out.Value = []byte("got:" + in.GetKey())
+	out.TtlMs = proto.Int64(p.ttlMs)
+	out.Hot = p.hot
+	return nil
+}
+
+func TestPeerResponseHints(t *testing.T) {
+	peer := &hintPeer{ttlMs: 50, hot: proto.Bool(true)}
+	g := newGroup("TestPeerResponseHints-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), fakePeers{peer})
+	var s string
+	for i := 0; i < 5; i++ {
+		if err := g.Get(dummyCtx, fmt.Sprintf("hot-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := g.CacheStats(HotCache).Items; n != 5 {
+		t.Errorf("hotCache has %d of 5 keys the peer called hot", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := g.Get(dummyCtx, "hot-0", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if peer.gets != 6 {
+		t.Errorf("peer gets = %d; want 6, the value should have expired", peer.gets)
+	}
+
+	peer.ttlMs = 0
+	peer.hot = proto.Bool(false)
+	for i := 0; i < 50; i++ {
+		if err := g.Get(dummyCtx, fmt.Sprintf("cold-%d", i), StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if v, ok := g.GetFromCache(fmt.Sprintf("cold-%d", i), HotCache); ok {
+			t.Fatalf("key the peer called not hot was mirrored: %q", v.String())
+		}
+	}
+}
+
+func TestLoadTimeout(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	var hang int32 = 1
+	g := newGroup("TestLoadTimeout-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		if atomic.LoadInt32(&hang) == 1 {
+			<-stuck
+		}
+		return dest.SetString("val:" + key)
+	}), NoPeers{})
+	g.LoadTimeout = 20 * time.Millisecond
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != singleflight.ErrTimeout {
+		t.Fatalf("Get of a hung load = %v; want singleflight.ErrTimeout", err)
+	}
+	atomic.StoreInt32(&hang, 0)
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil || s != "val:key" {
+		t.Errorf("Get after timeout = %q, %v; want %q, nil", s, err, "val:key")
+	}
+}
+
+func TestEmptyValueIsCached(t *testing.T) {
+	loads := 0
+	g := newGroup("TestEmptyValueIsCached-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		loads++
+		return dest.SetBytes([]byte{})
+	}), NoPeers{})
+	sinks := []func() Sink{
+		func() Sink { var s string; return StringSink(&s) },
+		func() Sink { var b []byte; return AllocatingByteSliceSink(&b) },
+		func() Sink { var v ByteView; return ByteViewSink(&v) },
+	}
+	for i, newSink := range sinks {
+		key := fmt.Sprintf("empty-%d", i)
+		for j := 0; j < 2; j++ {
+			dest := newSink()
+			if err := g.Get(dummyCtx, key, dest); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := dest.view(); !v.IsEmpty() {
+				t.Errorf("Get(%q) = %q; want an empty value", key, v.String())
+			}
+		}
+		if v, ok := g.lookupCache(key); !ok || !v.IsEmpty() {
+			t.Errorf("lookupCache(%q) = %q, %v; want an empty hit", key, v.String(), ok)
+		}
+	}
+	if loads != len(sinks) {
+		t.Errorf("loads = %d; want %d, one per key", loads, len(sinks))
+	}
+}
+
+func TestIsLoading(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	g := newGroup("TestIsLoading-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		close(started)
+		<-release
+		return dest.SetString("v")
+	}), NoPeers{})
+	if g.IsLoading("key") {
+		t.Error("IsLoading before any Get")
+	}
+	done := make(chan error)
+	go func() {
+		var s string
+		done <- g.Get(dummyCtx, "key", StringSink(&s))
+	}()
+	<-started
+	if !g.IsLoading("key") {
+		t.Error("IsLoading = false during the load")
+	}
+	if g.IsLoading("other") {
+		t.Error("IsLoading = true for a key nobody loads")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if g.IsLoading("key") {
+		t.Error("IsLoading = true after the load")
+	}
+}
+
+func TestAtomicIntSubAndCompareAndSwap(t *testing.T) {
+	var i AtomicInt
+	i.Add(5)
+	i.Sub(2)
+	if got := i.Get(); got != 3 {
+		t.Errorf("after Add(5), Sub(2): %d; want 3", got)
+	}
+	if i.CompareAndSwap(4, 10) {
+		t.Error("CompareAndSwap(4, 10) succeeded on 3")
+	}
+	if !i.CompareAndSwap(3, 10) || i.Get() != 10 {
+		t.Errorf("CompareAndSwap(3, 10) left %d; want 10", i.Get())
+	}
+}
+
+func TestMaxIdle(t *testing.T) {
+	g := newGroup("TestMaxIdle-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val:" + key)
+	}), NoPeers{})
+	g.MaxIdle = 30 * time.Millisecond
+	var s string
+	for _, key := range []string{"cold", "warm"} {
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if err := g.Get(dummyCtx, "warm", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := g.GetFromCache("cold", MainCache); ok {
+		t.Error("idle key is still cached")
+	}
+	if _, ok := g.GetFromCache("warm", MainCache); !ok {
+		t.Error("key in use was evicted")
+	}
+	if n := g.CacheStats(MainCache).Evictions; n != 1 {
+		t.Errorf("evictions = %d; want 1", n)
+	}
+}
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestSetClock(t *testing.T) {
+	var loads int
+	g := newGroup("TestSetClock-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		loads++
+		if key == "bad" {
+			return errors.New("bad key")
+		}
+		return dest.SetString("val:" + key)
+	}), NoPeers{})
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	g.SetClock(clock)
+	g.SetTTL(time.Minute)
+	g.SetErrorTTL(time.Minute)
+	g.MaxIdle = time.Hour
+
+	get := func(key string) error {
+		var s string
+		return g.Get(dummyCtx, key, StringSink(&s))
+	}
+	for _, key := range []string{"a", "bad"} {
+		get(key)
+	}
+	clock.Advance(59 * time.Second)
+	get("a")
+	if err := get("bad"); err == nil {
+		t.Error("cached error was forgotten early")
+	}
+	if loads != 2 {
+		t.Fatalf("loads = %d before expiry; want 2", loads)
+	}
+	clock.Advance(2 * time.Second)
+	get("a")
+	if err := get("bad"); err == nil {
+		t.Error("reload of bad key succeeded")
+	}
+	if loads != 4 {
+		t.Fatalf("loads = %d after expiry; want 4", loads)
+	}
+
+	g.SetTTL(0)
+	get("idle")
+	clock.Advance(2 * time.Hour)
+	get("a")
+	if _, ok := g.GetFromCache("idle", MainCache); ok {
+		t.Error("idle key is still cached")
+	}
+}
+
+func TestLoadTimeoutDoesNotWriteToSink(t *testing.T) {
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	g := newGroup("TestLoadTimeoutDoesNotWriteToSink-group", 1<<20, GetterFunc(func(ctx Context, key string, dest Sink) error {
+		<-ctx.(context.Context).Done()
+		done <- ctx.(context.Context).Err()
+		<-release
+		return dest.SetString("late:" + key)
+	}), NoPeers{})
+	g.LoadTimeout = 10 * time.Millisecond
+	buf := []byte("unchanged")
+	if err := g.Get(dummyCtx, "key", TruncatingByteSliceSink(&buf)); err != singleflight.ErrTimeout {
+		t.Fatalf("Get of a hung load = %v; want singleflight.ErrTimeout", err)
+	}
+	if err := <-done; err != context.DeadlineExceeded {
+		t.Errorf("Getter's context error = %v; want context.DeadlineExceeded", err)
+	}
+	// Reuse the buffer while the abandoned load finishes.
+	close(release)
+	for i := 0; i < 100; i++ {
+		buf[0] = 'u'
+		if string(buf) != "unchanged" {
+			t.Fatalf("buffer = %q after the load timed out; want it unchanged", buf)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRefreshAheadOutlivesRequest(t *testing.T) {
+	var fills AtomicInt
+	var fail int32
+	g := newGroup("TestRefreshAheadOutlivesRequest-group", 1<<20, GetterFunc(func(ctx Context, key string, dest Sink) error {
+		if fills.Get() > 0 {
+			// Give the request time to finish first.
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err := ctx.(context.Context).Err(); err != nil {
+			return err
+		}
+		if atomic.LoadInt32(&fail) == 1 {
+			return errors.New("refresh failed")
+		}
+		fills.Add(1)
+		return dest.SetString(fmt.Sprint(fills.Get()))
+	}), NoPeers{})
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	g.SetClock(clock)
+	g.SetTTL(time.Minute)
+	g.SetRefreshAhead(0.5)
+	get := func() string {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var s string
+		if err := g.Get(ctx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	get()
+	clock.Advance(40 * time.Second)
+	get()
+	deadline := time.Now().Add(5 * time.Second)
+	for fills.Get() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("refresh did not survive the end of the request")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	atomic.StoreInt32(&fail, 1)
+	errs := g.Stats.LocalLoadErrs.Get()
+	clock.Advance(40 * time.Second)
+	get()
+	for g.Stats.LocalLoadErrs.Get() == errs {
+		if time.Now().After(deadline) {
+			t.Fatal("failed refresh was not counted in LocalLoadErrs")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRefreshAheadWithExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	g := newGroup("TestRefreshAheadWithExpiry-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetBytesWithExpiry([]byte("v"), clock.Now().Add(10*time.Minute))
+	}), NoPeers{})
+	g.SetClock(clock)
+	g.SetTTL(time.Hour)
+	g.SetRefreshAhead(0.5)
+	var s string
+	if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	due := func() bool {
+		value, ok := g.lookupCache("key")
+		if !ok {
+			t.Fatal("key is not cached")
+		}
+		return g.dueForRefresh("key", value)
+	}
+	clock.Advance(time.Minute)
+	if due() {
+		t.Error("value a tenth of the way to its expiry is due for refresh")
+	}
+	clock.Advance(5 * time.Minute)
+	if !due() {
+		t.Error("value past half of its lifetime is not due for refresh")
+	}
+}
+
+func TestMaxIdleSetLate(t *testing.T) {
+	g := newGroup("TestMaxIdleSetLate-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("val:" + key)
+	}), NoPeers{})
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	g.SetClock(clock)
+	var s string
+	for _, key := range []string{"cold", "warm"} {
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g.mainCache.lru.TrackIdle {
+		t.Error("use times are tracked without MaxIdle")
+	}
+	g.MaxIdle = time.Minute
+	if err := g.Get(dummyCtx, "warm", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.GetFromCache("cold", MainCache); !ok {
+		t.Error("setting MaxIdle evicted a value at once")
+	}
+	clock.Advance(30 * time.Second)
+	g.Get(dummyCtx, "warm", StringSink(&s))
+	clock.Advance(40 * time.Second)
+	g.Get(dummyCtx, "warm", StringSink(&s))
+	if _, ok := g.GetFromCache("cold", MainCache); ok {
+		t.Error("idle key is still cached")
+	}
+	if _, ok := g.GetFromCache("warm", MainCache); !ok {
+		t.Error("key in use was evicted")
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	var cb circuitBreaker
+	cb.set(1, time.Minute, time.Second)
+	if _, err := cb.allow(now); err != nil {
+		t.Fatal(err)
+	}
+	cb.record(now, false, errors.New("fail"))
+	if _, err := cb.allow(now); err != ErrCircuitOpen {
+		t.Fatalf("allow after failure = %v; want ErrCircuitOpen", err)
+	}
+	now = now.Add(2 * time.Second)
+	probe, err := cb.allow(now)
+	if !probe || err != nil {
+		t.Fatalf("allow after cooldown = %v, %v; want a probe", probe, err)
+	}
+	// A load let through before the circuit opened must not decide
+	// the probe.
+	cb.record(now, false, errors.New("late failure"))
+	cb.record(now, false, nil)
+	if _, err := cb.allow(now); err != ErrCircuitOpen {
+		t.Errorf("allow during probe = %v; want ErrCircuitOpen", err)
+	}
+	cb.record(now, true, nil)
+	cb.endProbe()
+	if _, err := cb.allow(now); err != nil {
+		t.Errorf("allow after successful probe = %v; want nil", err)
+	}
+}
+
+func TestCircuitBreakerProbePanics(t *testing.T) {
+	var panicking int32 = 1
+	g := newGroup("TestCircuitBreakerProbePanics-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		if key == "probe" && atomic.LoadInt32(&panicking) == 1 {
+			panic("getter bug")
+		}
+		if key == "fail" {
+			return errors.New("backend down")
+		}
+		return dest.SetString("val")
+	}), NoPeers{})
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	g.SetClock(clock)
+	g.SetCircuitBreaker(1, time.Minute, time.Second)
+	var s string
+	g.Get(dummyCtx, "fail", StringSink(&s))
+	clock.Advance(2 * time.Second)
+	func() {
+		defer func() { recover() }()
+		g.Get(dummyCtx, "probe", StringSink(&s))
+	}()
+	if err := g.Get(dummyCtx, "next", StringSink(&s)); err != nil {
+		t.Errorf("Get after a panicking probe = %v; want a new probe to succeed", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresCanceledLoads(t *testing.T) {
+	g := newGroup("TestCircuitBreakerIgnoresCanceledLoads-group", 1<<20, GetterFunc(func(ctx Context, key string, dest Sink) error {
+		return ctx.(context.Context).Err()
+	}), NoPeers{})
+	g.SetCircuitBreaker(1, time.Minute, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var s string
+	for i := 0; i < 3; i++ {
+		g.Get(ctx, fmt.Sprintf("key-%d", i), StringSink(&s))
+	}
+	if _, err := g.breaker.allow(time.Now()); err != nil {
+		t.Errorf("canceled loads opened the circuit: %v", err)
+	}
+}
+
+func TestLoadRateLimitCanceled(t *testing.T) {
+	var loads int32
+	g := newGroup("TestLoadRateLimitCanceled-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		atomic.AddInt32(&loads, 1)
+		return dest.SetString("val:" + key)
+	}), NoPeers{})
+	clock := &fakeClock{now: time.Unix(1e9, 0)}
+	g.SetClock(clock)
+	g.SetLoadRateLimit(1, true)
+	var s string
+	if err := g.Get(dummyCtx, "a", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	// The next token is a second away on the group's clock.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := g.Get(ctx, "b", StringSink(&s)); err != context.DeadlineExceeded {
+		t.Errorf("Get waiting for a token = %v; want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("canceled Get waited %v for a token", d)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("loads = %d; want 1", n)
+	}
+	clock.Advance(time.Second)
+	if err := g.Get(dummyCtx, "c", StringSink(&s)); err != nil {
+		t.Errorf("Get after the clock moved on = %v; want nil", err)
+	}
+}
+
+func TestCompareAndSetLimits(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v1")
+	})
+	off := newGroup("TestCompareAndSetLimits-off", 0, getter, NoPeers{})
+	if _, err := off.CompareAndSet("key", []byte("v1"), []byte("v2")); err != ErrCachingDisabled {
+		t.Errorf("CompareAndSet without a cache = %v; want ErrCachingDisabled", err)
+	}
+	g := newGroup("TestCompareAndSetLimits-group", 64, getter, NoPeers{})
+	if _, err := g.CompareAndSet("key", []byte("v1"), make([]byte, 64)); err != ErrValueTooLarge {
+		t.Errorf("CompareAndSet of an oversized value = %v; want ErrValueTooLarge", err)
+	}
+
+	var s string
+	for _, key := range []string{"a", "b"} {
+		if err := g.Get(dummyCtx, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total := func() (n int64) {
+		mu.RLock()
+		defer mu.RUnlock()
+		for _, g := range groups {
+			n += g.mainCache.bytes() + g.hotCache.bytes()
+		}
+		return n
+	}
+	limit := total()
+	SetGlobalCacheLimit(limit)
+	defer SetGlobalCacheLimit(0)
+	if ok, err := g.CompareAndSet("b", []byte("v1"), []byte("v1-grown")); !ok || err != nil {
+		t.Fatalf("CompareAndSet = %v, %v; want true, nil", ok, err)
+	}
+	if n := total(); n > limit {
+		t.Errorf("caches hold %d bytes after CompareAndSet; want at most the global limit %d", n, limit)
+	}
+}
+
+// TODO(bradfitz): port the Google-internal full integration test into here,
+// using HTTP requests instead of our RPC system.
+
+func BenchmarkGetFromPeer(b *testing.B) {
+	g := &Group{name: "BenchmarkGetFromPeer-group"}
+	peer := &fakePeer{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := g.fetchFromPeer(dummyCtx, peer, "key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -40,6 +40,9 @@ func (m *GetRequest) GetKey() string {
 type GetResponse struct {
 	Value            []byte   `protobuf:"bytes,1,opt,name=value" json:"value,omitempty"`
 	MinuteQps        *float64 `protobuf:"fixed64,2,opt,name=minute_qps" json:"minute_qps,omitempty"`
+	Generation       *uint64  `protobuf:"varint,3,opt,name=generation" json:"generation,omitempty"`
+	TtlMs            *int64   `protobuf:"varint,4,opt,name=ttl_ms" json:"ttl_ms,omitempty"`
+	Hot              *bool    `protobuf:"varint,5,opt,name=hot" json:"hot,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -61,5 +64,42 @@ func (m *GetResponse) GetMinuteQps() float64 {
 	return 0
 }
 
+func (m *GetResponse) GetGeneration() uint64 {
+	if m != nil && m.Generation != nil {
+		return *m.Generation
+	}
+	return 0
+}
+
+func (m *GetResponse) GetTtlMs() int64 {
+	if m != nil && m.TtlMs != nil {
+		return *m.TtlMs
+	}
+	return 0
+}
+
+func (m *GetResponse) GetHot() bool {
+	if m != nil && m.Hot != nil {
+		return *m.Hot
+	}
+	return false
+}
+
+type GetMultiResponse struct {
+	Values           []*GetResponse `protobuf:"bytes,1,rep,name=values" json:"values,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *GetMultiResponse) Reset()         { *m = GetMultiResponse{} }
+func (m *GetMultiResponse) String() string { return proto.CompactTextString(m) }
+func (*GetMultiResponse) ProtoMessage()    {}
+
+func (m *GetMultiResponse) GetValues() []*GetResponse {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
 func init() {
 }
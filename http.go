@@ -18,10 +18,18 @@ package groupcache
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -35,6 +43,39 @@ const defaultBasePath = "/_groupcache/"
 
 const defaultReplicas = 50
 
+const defaultReadReplicas = 2
+
+// peerHeader is the request header in which a peer sends its own base
+// URL when fetching from another peer.
+const peerHeader = "X-Groupcache-Peer"
+
+// chunkHeader is the request header in which a peer asks for a value
+// to be streamed in chunks of at most the given number of bytes.
+const chunkHeader = "X-Groupcache-Chunk-Bytes"
+
+// chunkedContentType marks a response streamed in chunks. The body is
+// a series of frames, each a 4-byte big-endian length and that many
+// bytes: first a GetResponse without its value, then the value in
+// chunks, then an empty frame.
+const chunkedContentType = "application/x-groupcache-chunked"
+
+// maxChunkMetaBytes limits the size of the first frame of a chunked
+// response.
+const maxChunkMetaBytes = 64 << 10
+
+// RequestingPeer returns the base URL of the peer that sent r, as
+// given to its NewHTTPPool, or "" if r did not come from a peer.
+// It is intended for use in HTTPPool.Context, so that Getters can
+// tell which peer asked for a key, for example in logs or metrics.
+//
+// The value is a header set by the client, which any client can
+// forge, so it must not be used for authorization or any other trust
+// decision. Authenticate peers at the transport instead, for example
+// with mutual TLS.
+func RequestingPeer(r *http.Request) string {
+	return r.Header.Get(peerHeader)
+}
+
 // HTTPPool implements PeerPicker for a pool of HTTP peers.
 type HTTPPool struct {
 	// Context optionally specifies a context for the server to use when it
@@ -45,17 +86,68 @@ type HTTPPool struct {
 	// Transport optionally specifies an http.RoundTripper for the client
 	// to use when it makes a request.
 	// If nil, the client uses http.DefaultTransport.
+	//
+	// It is called with the same Context value that was passed to
+	// Group.Get, so request-scoped data such as credentials can be
+	// threaded through to the peer request. When concurrent Gets for
+	// a key are deduplicated, only the first caller's Context is used.
+	// Transport must be set before calling Set.
+	//
+	// To talk to peers over HTTP/2, return an *http.Transport with
+	// ForceAttemptHTTP2 set (for https peers), or a transport from
+	// golang.org/x/net/http2 (for cleartext h2c peers).
 	Transport func(Context) http.RoundTripper
 
+	// OnRebalance optionally specifies a function to call after Set
+	// changes the pool's membership, with the peers that were added
+	// and removed, or after SetReplicas changes the replica count,
+	// with neither. Key ownership changes with either, so it is a
+	// good place to call Group.RemoveUnowned.
+	OnRebalance func(added, removed []string)
+
+	// PeerPreference optionally chooses which peer PickPeer sends a
+	// key to, among the key's first ReadReplicas owners on the
+	// consistent hash (primary owner first), for example the one in
+	// the same availability zone. If it returns a value that is not
+	// a candidate, the primary owner is used. When this peer is
+	// itself a candidate, it serves the key locally and
+	// PeerPreference is not called, so that peers with different
+	// preferences cannot forward a key back and forth.
+	// It must be set on every peer before the pool is used.
+	PeerPreference func(candidates []string) string
+
+	// Propagator optionally injects request-scoped values, such as a
+	// trace context, into the headers of requests sent to peers. It
+	// is called only when the Context passed to Group.Get is a
+	// context.Context. Propagator must be set before calling Set.
+	Propagator func(context.Context, http.Header)
+
+	// Extractor optionally reverses Propagator on the serving side:
+	// it returns a context derived from ctx that carries the values
+	// found in the request headers. ctx is the result of Context if
+	// that is a context.Context, or the request's context if Context
+	// is nil. Getters then see the returned context.
+	Extractor func(ctx context.Context, h http.Header) context.Context
+
+	// NamespaceKeys makes the pool place a key of a group by hashing
+	// group + "/" + key instead of the key alone, so that the same key
+	// in different groups can be owned by different peers. Keys given
+	// to PinKey must then be namespaced the same way. It must be set
+	// alike on every peer, before the pool is used.
+	NamespaceKeys bool
+
 	// this peer's base URL, e.g. "https://example.net:8000"
 	self string //self 必须是一个合法的URL指向当前的服务器，比如 "http://10.0.0.1:8000"
 
 	// opts specifies the options.
 	opts HTTPPoolOptions
 
-	mu          sync.Mutex // guards peers and httpGetters
+	mu          sync.Mutex // guards peers, httpGetters and pins
 	peers       *consistenthash.Map
 	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	pins        map[string]string      // key -> peer, see PinKey
+	registered  bool                   // made by NewHTTPPoolOpts
+	closed      bool
 }
 
 // HTTPPoolOptions are the configurations of a HTTPPool.
@@ -70,7 +162,40 @@ type HTTPPoolOptions struct {
 
 	// HashFn specifies the hash function of the consistent hash.
 	// If blank, it defaults to crc32.ChecksumIEEE.
+	// consistenthash.XXHash spreads keys more evenly.
+	// Tests can use consistenthash.StaticHash to route known keys
+	// to known peers.
 	HashFn consistenthash.Hash // 分布式一致性hash的hash算法，默认 crc32.ChecksumIEEE.
+
+	// MaxResponseBytes limits the size of a response body read from
+	// a peer. Larger responses are rejected with an error.
+	// If zero, responses are unlimited.
+	MaxResponseBytes int64
+
+	// EnableCompression makes the pool ask peers for gzip-compressed
+	// responses, and compress its own responses for peers that ask.
+	// It should be set on every peer; a peer without it answers
+	// uncompressed, which still works.
+	EnableCompression bool
+
+	// ReadReplicas specifies how many owners of a key are offered to
+	// HTTPPool.PeerPreference. If blank, it defaults to 2.
+	ReadReplicas int
+
+	// MaxChunkBytes, if positive, makes the pool ask peers to stream
+	// a value in chunks of at most this many bytes, instead of in a
+	// single protobuf message, which avoids marshaling and
+	// unmarshaling large values whole. Peers that do not support
+	// chunks answer as usual. MaxResponseBytes still limits the
+	// total size of the value.
+	MaxChunkBytes int
+
+	// IncludeSelf makes the pool put its own URL on the consistent
+	// hash even when it is missing from the peers given to Set. Then a
+	// peer that was briefly left out of its own peer list, such as
+	// during a membership change, still owns its share of the keys
+	// instead of forwarding them all to other peers.
+	IncludeSelf bool
 }
 
 //初始化一个对等节点的HTTPPool,把自己注册成一个对等节点选取器，也把自己注册成p.opts.BasePath路由的处理器。
@@ -87,13 +212,25 @@ var httpPoolMade bool
 // Unlike NewHTTPPool, this function does not register the created pool as an HTTP handler.
 // The returned *HTTPPool implements http.Handler and must be registered using http.Handle.
 func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
+	portPickerMu.Lock()
 	if httpPoolMade { //只调用一次
+		portPickerMu.Unlock()
 		panic("groupcache: NewHTTPPool must be called only once")
 	}
 	httpPoolMade = true
+	portPickerMu.Unlock()
 
+	p := newHTTPPool(self, o)
+	p.registered = true
+	RegisterPeerPicker(func() PeerPicker { return p }) // 注册peers.portPicker,看到没，此处就是用的是闭包，这个p是存放在堆上的。
+	return p
+}
+
+// newHTTPPool returns an HTTPPool without registering it as the
+// package's PeerPicker.
+func newHTTPPool(self string, o *HTTPPoolOptions) *HTTPPool {
 	p := &HTTPPool{
-		self:        self,                         //使用self参数（基础节点的url）初始化一个 HTTPPool对象
+		self:        normalizePeer(self),                         //使用self参数（基础节点的url）初始化一个 HTTPPool对象
 		httpGetters: make(map[string]*httpGetter), //在下面的Set中被填充
 	}
 	if o != nil {
@@ -105,38 +242,316 @@ func NewHTTPPoolOpts(self string, o *HTTPPoolOptions) *HTTPPool {
 	if p.opts.Replicas == 0 {
 		p.opts.Replicas = defaultReplicas //默认复制节点的个数
 	}
+	if p.opts.ReadReplicas == 0 {
+		p.opts.ReadReplicas = defaultReadReplicas
+	}
 	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn) // 根据虚拟节点数量和哈希函数创建一致性哈希节点对象,但是此处并没有创建key或者hashmap，本机节点默认这两个值是0
-
-	RegisterPeerPicker(func() PeerPicker { return p }) // 注册peers.portPicker,看到没，此处就是用的是闭包，这个p是存放在堆上的。
 	return p
 }
 
 // Set updates the pool's list of peers.
 // Each peer value should be a valid base URL,
-// for example "http://example.net:8000".
+// for example "http://example.net:8000". SetE checks this.
+// A trailing slash is dropped, as it is from self.
 func (p *HTTPPool) Set(peers ...string) { // 更新节点列表，用了consistenthash
+	normalized := make([]string, len(peers))
+	for i, peer := range peers {
+		normalized[i] = normalizePeer(peer)
+	}
+	peers = normalized
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	added, removed := diffPeers(p.httpGetters, peers)
+	p.setLocked(peers...)
+	p.mu.Unlock()
+	if len(added) > 0 || len(removed) > 0 {
+		rebalanceGroups(p)
+		if p.OnRebalance != nil {
+			p.OnRebalance(added, removed)
+		}
+	}
+}
+
+// Close releases the pool. It drops all peers, so that groups
+// still using the pool load every key locally, and closes the idle
+// connections of http.DefaultTransport if Transport is nil; idle
+// connections of a custom Transport are left to its owner. If the
+// pool was made by NewHTTPPool or NewHTTPPoolOpts, Close also
+// unregisters it as the package's PeerPicker, so that a new pool can
+// be made, for example by the next test. Groups created before Close
+// keep the PeerPicker they already have.
+//
+// Close cannot remove the handler NewHTTPPool registered with
+// http.Handle, so calling NewHTTPPool again would still panic; make
+// the next pool with NewHTTPPoolOpts and serve it on its own
+// ServeMux. Calling Close more than once has no further effect.
+func (p *HTTPPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
 	p.peers = consistenthash.New(p.opts.Replicas, p.opts.HashFn)
-	p.peers.Add(peers...)
+	p.httpGetters = make(map[string]*httpGetter)
+	p.pins = nil
+	p.mu.Unlock()
+
+	if p.registered {
+		portPickerMu.Lock()
+		portPicker = nil
+		httpPoolMade = false
+		portPickerMu.Unlock()
+	}
+	if p.Transport == nil {
+		if tr, ok := http.DefaultTransport.(interface{ CloseIdleConnections() }); ok {
+			tr.CloseIdleConnections()
+		}
+	}
+	return nil
+}
+
+// SetE is like Set, but first checks that each peer is an absolute
+// http or https URL with a host and no query, and normalizes it by
+// dropping a trailing slash. If any peer is malformed, SetE returns
+// an error and leaves the pool unchanged.
+func (p *HTTPPool) SetE(peers ...string) error {
+	normalized := make([]string, len(peers))
+	for i, peer := range peers {
+		u, err := normalizePeerURL(peer)
+		if err != nil {
+			return err
+		}
+		normalized[i] = u
+	}
+	p.Set(normalized...)
+	return nil
+}
+
+// normalizePeerURL checks that s is a valid peer base URL and returns
+// it without a trailing slash.
+func normalizePeerURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("groupcache: invalid peer URL %q: %v", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("groupcache: peer URL %q must start with http:// or https://", s)
+	}
+	if u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("groupcache: peer URL %q must be a base URL such as http://example.net:8000", s)
+	}
+	return strings.TrimRight(s, "/"), nil
+}
+
+// normalizePeer returns s normalized by normalizePeerURL, or s itself
+// if it is not a valid peer URL.
+func normalizePeer(s string) string {
+	if u, err := normalizePeerURL(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// diffPeers returns the peers that are in peers but not in old, and
+// those in old but not in peers.
+func diffPeers(old map[string]*httpGetter, peers []string) (added, removed []string) {
+	cur := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		cur[peer] = true
+		if _, ok := old[peer]; !ok {
+			added = append(added, peer)
+		}
+	}
+	for peer := range old {
+		if !cur[peer] {
+			removed = append(removed, peer)
+		}
+	}
+	sort.Strings(removed)
+	return added, removed
+}
+
+func (p *HTTPPool) setLocked(peers ...string) {
+	p.peers = p.newRing(peers)
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{transport: p.Transport, baseURL: peer + p.opts.BasePath} //baseURL就类似为http://127.0.0.1:8081/_groupcache/
+		p.httpGetters[peer] = &httpGetter{
+			self:             p.self,
+			transport:        p.Transport,
+			propagator:       p.Propagator,
+			baseURL:          peer + p.opts.BasePath, //baseURL就类似为http://127.0.0.1:8081/_groupcache/
+			maxResponseBytes: p.opts.MaxResponseBytes,
+			maxChunkBytes:    p.opts.MaxChunkBytes,
+			compress:         p.opts.EnableCompression,
+		}
+	}
+}
+
+// newRing returns a consistent hash of peers, to which self is added
+// if IncludeSelf is set.
+func (p *HTTPPool) newRing(peers []string) *consistenthash.Map {
+	m := consistenthash.New(p.opts.Replicas, p.opts.HashFn)
+	m.Add(peers...)
+	if p.opts.IncludeSelf && p.self != "" {
+		for _, peer := range peers {
+			if peer == p.self {
+				return m
+			}
+		}
+		m.Add(p.self)
 	}
+	return m
+}
+
+// SetReplicas rebuilds the consistent hash from the current peers
+// using n replicas per peer. A value of 0 selects the default; a
+// negative value panics.
+//
+// Changing the replica count reshuffles key ownership across the
+// pool, so every process in the pool should use the same value. As
+// after Set, groups are rebalanced and OnRebalance is called, with
+// no peers added or removed.
+func (p *HTTPPool) SetReplicas(n int) {
+	if n < 0 {
+		panic("groupcache: negative replica count")
+	}
+	if n == 0 {
+		n = defaultReplicas
+	}
+	p.mu.Lock()
+	if n == p.opts.Replicas {
+		p.mu.Unlock()
+		return
+	}
+	p.opts.Replicas = n
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		peers = append(peers, peer)
+	}
+	p.peers = p.newRing(peers)
+	p.mu.Unlock()
+	rebalanceGroups(p)
+	if p.OnRebalance != nil {
+		p.OnRebalance(nil, nil)
+	}
+}
+
+// Ring returns the virtual nodes of the pool's consistent hash, in
+// ascending hash order, for debugging uneven load across peers.
+func (p *HTTPPool) Ring() []consistenthash.RingEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peers.Export()
+}
+
+// PeerKey implements GroupKeyer.
+func (p *HTTPPool) PeerKey(group, key string) string {
+	if p.NamespaceKeys {
+		return group + "/" + key
+	}
+	return key
+}
+
+// PinKey routes key to peer regardless of the consistent hash, until
+// UnpinKey is called. The peer must be self or one of the peers given
+// to Set; pins to unknown peers are ignored by PickPeer.
+func (p *HTTPPool) PinKey(key, peer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pins == nil {
+		p.pins = make(map[string]string)
+	}
+	p.pins[key] = normalizePeer(peer)
+}
+
+// UnpinKey removes a pin set by PinKey.
+func (p *HTTPPool) UnpinKey(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pins, key)
 }
 
 func (p *HTTPPool) PickPeer(key string) (ProtoGetter, bool) { // 用一致性hash算法选择一个节点，拿服务器节点的。
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if peer, ok := p.pins[key]; ok {
+		if peer == p.self {
+			return nil, false
+		}
+		if getter, ok := p.httpGetters[peer]; ok {
+			return getter, true
+		}
+	}
 	if p.peers.IsEmpty() {
 		return nil, false
 	}
+	if p.PeerPreference != nil {
+		return p.pickPreferred(key)
+	}
 	if peer := p.peers.Get(key); peer != p.self { //如果拿到的节点地址不是本机的节点地址
 		return p.httpGetters[peer], true
 	}
 	return nil, false //如果查节点，查到自己，那后续就不用再从其他节点拿数据了
 }
 
+// Owner implements OwnerPicker. It returns the base URL of the peer
+// that owns key, as given to Set, honoring keys pinned with PinKey.
+// With no peers set, every key is owned by self.
+func (p *HTTPPool) Owner(key string) (peer string, isSelf bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if peer, ok := p.pins[key]; ok {
+		if _, known := p.httpGetters[peer]; known || peer == p.self {
+			return peer, peer == p.self
+		}
+	}
+	if p.peers.IsEmpty() {
+		return p.self, true
+	}
+	peer = p.peers.Get(key)
+	return peer, peer == p.self
+}
+
+// pickPreferred picks the peer for key with PeerPreference.
+// p.mu must be held.
+func (p *HTTPPool) pickPreferred(key string) (ProtoGetter, bool) {
+	candidates := p.peers.GetN(key, p.opts.ReadReplicas)
+	for _, c := range candidates {
+		if c == p.self {
+			return nil, false
+		}
+	}
+	peer := candidates[0]
+	if choice := p.PeerPreference(candidates); choice != peer {
+		for _, c := range candidates[1:] {
+			if c == choice {
+				peer = choice
+				break
+			}
+		}
+	}
+	return p.httpGetters[peer], true
+}
+
+// PickPeers implements ReplicaPicker. It returns the remote peers
+// among the first n owners of key on the consistent hash, primary
+// owner first, skipping self.
+func (p *HTTPPool) PickPeers(key string, n int) ([]ProtoGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	owners := p.peers.GetN(key, n)
+	if len(owners) == 0 || owners[0] == p.self {
+		return nil, false
+	}
+	peers := make([]ProtoGetter, 0, len(owners))
+	for _, owner := range owners {
+		if owner != p.self {
+			peers = append(peers, p.httpGetters[owner])
+		}
+	}
+	return peers, true
+}
+
 // 根据请求的路径获取Group和Key，发送请求并返回结果
 //请求历经类似为https://example.net:8000/_groupcache/groupname/key
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) { // 用于处理其他节点通过HTTP传递过来的http请求
@@ -144,13 +559,32 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) { // 用于
 	if !strings.HasPrefix(r.URL.Path, p.opts.BasePath) { // 判断URL前缀是否合法
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
-	parts := strings.SplitN(r.URL.Path[len(p.opts.BasePath):], "/", 2) // 分割URL，并从中提取group和key值，示例请求URL为：https://example.net:8000/_groupcache/groupname/key
+	// Split the escaped path so that an escaped "/" in the group
+	// name or key does not act as a separator.
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, p.opts.BasePath) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	parts := strings.SplitN(path[len(p.opts.BasePath):], "/", 2) // 分割URL，并从中提取group和key值，示例请求URL为：https://example.net:8000/_groupcache/groupname/key
+	if len(parts) == 1 && r.URL.Query()["key"] != nil {
+		p.serveMulti(w, r, parts[0])
+		return
+	}
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	groupName := parts[0]
-	key := parts[1]
+	groupName, err := url.QueryUnescape(parts[0])
+	if err != nil {
+		http.Error(w, "bad group name", http.StatusBadRequest)
+		return
+	}
+	key, err := url.QueryUnescape(parts[1])
+	if err != nil {
+		http.Error(w, "bad key", http.StatusBadRequest)
+		return
+	}
 
 	// Fetch the value for this group/key.
 	group := GetGroup(groupName) // 根据url中提取的groupname获取group
@@ -158,32 +592,338 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) { // 用于
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
-	var ctx Context
-	if p.Context != nil { // 如Context不为空，说明需要使用定制的context
-		ctx = p.Context(r)
+	if err := group.checkKey(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := p.requestContext(r)
+
+	if r.Method == http.MethodPut {
+		p.servePut(w, r, group, key)
+		return
 	}
 
 	group.Stats.ServerRequests.Add(1)
-	var value []byte
-	err := group.Get(ctx, key, AllocatingByteSliceSink(&value)) // 获取指定key对应的值，也是先从缓存拿，缓存拿不到就从磁盘拿
+	if r.Method == http.MethodHead {
+		p.serveHead(w, r, ctx, group, key)
+		return
+	}
+	var value ByteView
+	err = group.Get(ctx, key, ByteViewSink(&value)) // 获取指定key对应的值，也是先从缓存拿，缓存拿不到就从磁盘拿
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if n, err := strconv.Atoi(r.Header.Get(chunkHeader)); err == nil && n > 0 {
+		p.writeChunked(w, r, group.peerMeta(key, value), value.BytesNoCopy(), n)
+		return
+	}
 	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.GetResponse{Value: value}) //序列化响应内容
+	p.writeResponse(w, r, group.peerResponse(key, value))
+}
+
+// ContentHandler returns an http.Handler that serves the value of the
+// key named by the request path after prefix, for clients rather
+// than peers. It uses http.ServeContent, so Range, If-Modified-Since
+// (with the time from Group.LastModified) and content type detection
+// from the key's extension are supported. The request's context is
+// passed to Group.Get.
+func ContentHandler(g *Group, prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			http.NotFound(w, r)
+			return
+		}
+		key := r.URL.Path[len(prefix):]
+		var value ByteView
+		if err := g.Get(r.Context(), key, ByteViewSink(&value)); err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrKeyTooLong) {
+				code = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		modtime, _ := g.LastModified(key)
+		http.ServeContent(w, r, key, modtime, value.Reader())
+	})
+}
+
+// requestContext returns the Context to load values for r with.
+func (p *HTTPPool) requestContext(r *http.Request) Context {
+	var ctx Context
+	if p.Context != nil { // 如Context不为空，说明需要使用定制的context
+		ctx = p.Context(r)
+	}
+	if p.Extractor == nil {
+		return ctx
+	}
+	if ctx == nil {
+		return p.Extractor(r.Context(), r.Header)
+	}
+	if c, ok := ctx.(context.Context); ok {
+		return p.Extractor(c, r.Header)
+	}
+	return ctx
+}
+
+// serveHead answers a HEAD request for key with the value's length
+// and no body. With the query parameter "cacheonly" set, only this
+// process's caches are consulted and nothing is loaded, and a miss is
+// answered with 404. Otherwise a failed load is answered with 500, as
+// for a GET, since it does not show that the key has no value.
+func (p *HTTPPool) serveHead(w http.ResponseWriter, r *http.Request, ctx Context, group *Group, key string) {
+	var view ByteView
+	if _, ok := r.URL.Query()["cacheonly"]; ok {
+		v, cacheHit := group.lookupCache(key)
+		if !cacheHit {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		view = v
+	} else if err := group.Get(ctx, key, ByteViewSink(&view)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(view.Len()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// servePut stores the request body as the value of key in the
+// group's main cache. Peers send such requests when
+// Group.PushToOwnerOnLoad is set. They are refused unless the group
+// sets AcceptPushes and this peer owns key.
+func (p *HTTPPool) servePut(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	if !group.AcceptPushes {
+		http.Error(w, "group does not accept pushes", http.StatusForbidden)
+		return
+	}
+	if _, isSelf := p.Owner(p.PeerKey(group.name, key)); !isSelf {
+		http.Error(w, "key not owned by this peer", http.StatusMisdirectedRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, group.cacheBytes+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > group.cacheBytes {
+		http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	group.populateCache(key, ByteView{b: body}, &group.mainCache)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveMulti serves a batch request for the keys given as repeated
+// "key" query parameters, e.g. /_groupcache/groupname?key=a&key=b,
+// with a GetMultiResponse. The whole request fails if any key does.
+func (p *HTTPPool) serveMulti(w http.ResponseWriter, r *http.Request, escapedGroup string) {
+	groupName, err := url.QueryUnescape(escapedGroup)
+	if err != nil {
+		http.Error(w, "bad group name", http.StatusBadRequest)
+		return
+	}
+	group := GetGroup(groupName)
+	if group == nil {
+		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	keys := r.URL.Query()["key"]
+	for _, key := range keys {
+		if err := group.checkKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	ctx := p.requestContext(r)
+
+	res := &pb.GetMultiResponse{Values: make([]*pb.GetResponse, len(keys))}
+	for i, key := range keys {
+		group.Stats.ServerRequests.Add(1)
+		var value ByteView
+		if err := group.Get(ctx, key, ByteViewSink(&value)); err != nil {
+			http.Error(w, key+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Values[i] = group.peerResponse(key, value)
+	}
+	p.writeResponse(w, r, res)
+}
+
+// writeResponse writes msg as the response body, compressing it if
+// the pool and the client agree to.
+func (p *HTTPPool) writeResponse(w http.ResponseWriter, r *http.Request, msg proto.Message) {
+	body, err := proto.Marshal(msg) //序列化响应内容
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/x-protobuf") // 设置http头
-	w.Write(body)                                            //设置http  body
+	if p.opts.EnableCompression && acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+		zw := gzip.NewWriter(w)
+		zw.Write(body)
+		zw.Close()
+		return
+	}
+	w.Write(body) //设置http  body
+}
+
+// writeChunked writes meta and then value, in chunks of at most n
+// bytes, in the format of chunkedContentType.
+func (p *HTTPPool) writeChunked(w http.ResponseWriter, r *http.Request, meta *pb.GetResponse, value []byte, n int) {
+	head, err := proto.Marshal(meta)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", chunkedContentType)
+	var out io.Writer = w
+	if p.opts.EnableCompression && acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+		zw := gzip.NewWriter(w)
+		defer zw.Close()
+		out = zw
+	}
+	if writeFrame(out, head) != nil {
+		return
+	}
+	for len(value) > 0 {
+		chunk := value
+		if len(chunk) > n {
+			chunk = chunk[:n]
+		}
+		if writeFrame(out, chunk) != nil {
+			return
+		}
+		value = value[len(chunk):]
+	}
+	writeFrame(out, nil)
+}
+
+// writeFrame writes b to w preceded by its length.
+func writeFrame(w io.Writer, b []byte) error {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(b)))
+	if _, err := w.Write(n[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// acceptsGzip reports whether r accepts gzip-encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
 }
 
 type httpGetter struct { // 这里实际上实现了Peer模块中的ProtoGetter接口
+	self      string // base URL of the requesting pool, sent in peerHeader
 	transport func(Context) http.RoundTripper
 	baseURL   string
+
+	// propagator, if non-nil, injects values from the request's
+	// context.Context into its headers.
+	propagator func(context.Context, http.Header)
+
+	// maxResponseBytes, if positive, limits the response body size.
+	maxResponseBytes int64
+
+	// compress requests gzip-compressed responses.
+	compress bool
+
+	// maxChunkBytes, if positive, asks for values to be streamed in
+	// chunks of at most this size.
+	maxChunkBytes int
+}
+
+var (
+	// ErrNoSuchGroup is reported, wrapped in a *PeerStatusError, when a
+	// peer does not have the requested group.
+	ErrNoSuchGroup = errors.New("groupcache: peer has no such group")
+
+	// ErrBadPeerRequest is reported, wrapped in a *PeerStatusError,
+	// when a peer rejects a request as malformed, for example because
+	// the key is too long.
+	ErrBadPeerRequest = errors.New("groupcache: peer rejected request")
+)
+
+// PeerStatusError is returned by the HTTP peer client when a peer
+// responds with a status other than 200 OK. Use errors.Is with
+// ErrNoSuchGroup or ErrBadPeerRequest to classify it.
+type PeerStatusError struct {
+	StatusCode int
+	Status     string
+	Body       string // start of the response body, for diagnostics
+	Err        error  // ErrNoSuchGroup, ErrBadPeerRequest or nil
+}
+
+func (e *PeerStatusError) Error() string {
+	msg := "server returned: " + e.Status
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error for the status code, if any.
+func (e *PeerStatusError) Unwrap() error { return e.Err }
+
+// maxErrorBodyBytes bounds how much of an error response is kept in a
+// PeerStatusError.
+const maxErrorBodyBytes = 512
+
+func newPeerStatusError(res *http.Response) *PeerStatusError {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodyBytes))
+	e := &PeerStatusError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Body:       strings.TrimSpace(string(body)),
+	}
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		e.Err = ErrNoSuchGroup
+	case http.StatusBadRequest:
+		e.Err = ErrBadPeerRequest
+	}
+	return e
+}
+
+// PeerDecodeError is returned by the HTTP peer client when a peer's
+// response body cannot be decoded, for example because it was
+// truncated. Unlike transport errors and a *PeerStatusError, it means
+// the peer answered but with a malformed value.
+// See Group.RetryReplicaOnDecodeError.
+type PeerDecodeError struct {
+	URL    string // the URL that was fetched
+	Prefix []byte // start of the response body, for diagnostics
+	Err    error  // the decoding error
+}
+
+func (e *PeerDecodeError) Error() string {
+	return fmt.Sprintf("decoding response body from %s: %v; body starts:\n%s", e.URL, e.Err, hex.Dump(e.Prefix))
+}
+
+func (e *PeerDecodeError) Unwrap() error { return e.Err }
+
+// maxDecodeErrorPrefix bounds how much of an undecodable response is
+// kept in a PeerDecodeError.
+const maxDecodeErrorPrefix = 64
+
+func newPeerDecodeError(u string, body []byte, err error) *PeerDecodeError {
+	if len(body) > maxDecodeErrorPrefix {
+		body = body[:maxDecodeErrorPrefix]
+	}
+	return &PeerDecodeError{URL: u, Prefix: cloneBytes(body), Err: err}
 }
 
 var bufferPool = sync.Pool{
@@ -202,10 +942,72 @@ func (h *httpGetter) Get(context Context, in *pb.GetRequest, out *pb.GetResponse
 		url.QueryEscape(in.GetGroup()),
 		url.QueryEscape(in.GetKey()),
 	)
-	req, err := http.NewRequest("GET", u, nil) // 新建Get请求
+	return h.get(context, u, out)
+}
+
+// Put implements Pusher.
+func (h *httpGetter) Put(context Context, group, key string, value []byte) error {
+	u := h.baseURL + url.QueryEscape(group) + "/" + url.QueryEscape(key)
+	req, err := h.newRequest(context, "PUT", u, bytes.NewReader(value))
 	if err != nil {
 		return err
 	}
+	tr := http.DefaultTransport
+	if h.transport != nil {
+		tr = h.transport(context)
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return newPeerStatusError(res)
+	}
+	return nil
+}
+
+// GetMulti implements MultiGetter.
+func (h *httpGetter) GetMulti(context Context, group string, keys []string, out *pb.GetMultiResponse) error {
+	u := h.baseURL + url.QueryEscape(group) + "?" + url.Values{"key": keys}.Encode()
+	if err := h.get(context, u, out); err != nil {
+		return err
+	}
+	if len(out.Values) != len(keys) {
+		return fmt.Errorf("peer returned %d values for %d keys", len(out.Values), len(keys))
+	}
+	return nil
+}
+
+// newRequest returns a request to the peer carrying this peer's
+// identity and any values injected by the propagator.
+func (h *httpGetter) newRequest(ctx Context, method, u string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if h.self != "" {
+		req.Header.Set(peerHeader, h.self)
+	}
+	if c, ok := ctx.(context.Context); ok && h.propagator != nil {
+		h.propagator(c, req.Header)
+	}
+	return req, nil
+}
+
+// get fetches u from the peer and decodes the response into out.
+func (h *httpGetter) get(context Context, u string, out proto.Message) error {
+	req, err := h.newRequest(context, "GET", u, nil) // 新建Get请求
+	if err != nil {
+		return err
+	}
+	if h.compress {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	getRes, isGet := out.(*pb.GetResponse)
+	if isGet && h.maxChunkBytes > 0 {
+		req.Header.Set(chunkHeader, strconv.Itoa(h.maxChunkBytes))
+	}
 	tr := http.DefaultTransport //获取transport方法
 	if h.transport != nil {
 		tr = h.transport(context)
@@ -216,18 +1018,88 @@ func (h *httpGetter) Get(context Context, in *pb.GetRequest, out *pb.GetResponse
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		return newPeerStatusError(res)
 	}
 	b := bufferPool.Get().(*bytes.Buffer) // 这里用到了go 提供的 sync.Pool，对字节缓冲数组进行复用，避免了反复申请（缓存期为两次gc之间）
 	b.Reset()                             //字节缓冲重置
 	defer bufferPool.Put(b)
-	_, err = io.Copy(b, res.Body) //字节缓冲填充
+	var body io.Reader = res.Body
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+		defer zr.Close()
+		body = zr
+	}
+	if isGet && res.Header.Get("Content-Type") == chunkedContentType {
+		return h.readChunked(u, body, getRes)
+	}
+	if h.maxResponseBytes > 0 {
+		body = io.LimitReader(body, h.maxResponseBytes+1)
+	}
+	_, err = io.Copy(b, body) //字节缓冲填充
 	if err != nil {
 		return fmt.Errorf("reading response body: %v", err)
 	}
+	if h.maxResponseBytes > 0 && int64(b.Len()) > h.maxResponseBytes {
+		return fmt.Errorf("response body exceeds %d bytes", h.maxResponseBytes)
+	}
 	err = proto.Unmarshal(b.Bytes(), out) //反序列化字节数组
 	if err != nil {
-		return fmt.Errorf("decoding response body: %v", err)
+		return newPeerDecodeError(u, b.Bytes(), err)
 	}
 	return nil
 }
+
+// readChunked decodes a response body in the format of
+// chunkedContentType into out.
+func (h *httpGetter) readChunked(u string, body io.Reader, out *pb.GetResponse) error {
+	head, err := readFrame(body, maxChunkMetaBytes)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if err := proto.Unmarshal(head, out); err != nil {
+		return newPeerDecodeError(u, head, err)
+	}
+	limit := h.maxChunkBytes
+	if limit <= 0 {
+		limit = maxChunkMetaBytes
+	}
+	var value []byte
+	for {
+		chunk, err := readFrame(body, limit)
+		if err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		if h.maxResponseBytes > 0 && int64(len(value)+len(chunk)) > h.maxResponseBytes {
+			return fmt.Errorf("response body exceeds %d bytes", h.maxResponseBytes)
+		}
+		value = append(value, chunk...)
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	out.Value = value
+	return nil
+}
+
+// readFrame reads a frame written by writeFrame, of at most max bytes.
+func readFrame(r io.Reader, max int) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(n[:])
+	if int64(size) > int64(max) {
+		return nil, fmt.Errorf("frame of %d bytes exceeds %d", size, max)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
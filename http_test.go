@@ -17,11 +17,15 @@ limitations under the License.
 package groupcache
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"strconv"
@@ -29,6 +33,11 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"groupcache/consistenthash"
+	pb "groupcache/groupcachepb"
 )
 
 var (
@@ -80,6 +89,7 @@ func TestHTTPPool(t *testing.T) {
 
 	// Use a dummy self address so that we don't handle gets in-process.
 	p := NewHTTPPool("should-be-ignored")
+	defer p.Close()
 	p.Set(addrToURL(childAddr)...)
 
 	// Dummy getter function. Gets should go to children only.
@@ -164,3 +174,979 @@ func awaitAddrReady(t *testing.T, addr string, wg *sync.WaitGroup) {
 		time.Sleep(delay)
 	}
 }
+
+func TestHTTPGetterMaxResponseBytes(t *testing.T) {
+	body, err := proto.Marshal(&pb.GetResponse{Value: []byte(strings.Repeat("x", 100))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	req := &pb.GetRequest{Group: proto.String("group"), Key: proto.String("key")}
+	h := &httpGetter{baseURL: ts.URL + "/", maxResponseBytes: int64(len(body))}
+	if err := h.Get(nil, req, &pb.GetResponse{}); err != nil {
+		t.Errorf("Get with body at the limit: %v", err)
+	}
+	h.maxResponseBytes = int64(len(body)) - 1
+	if err := h.Get(nil, req, &pb.GetResponse{}); err == nil {
+		t.Error("Get with body over the limit succeeded; want error")
+	}
+}
+
+func TestHTTPPoolPinKey(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	p.Set("http://a", "http://b", "http://c")
+	key := "pinned-key"
+	owner := p.peers.Get(key)
+	target := "http://b"
+	if owner == target {
+		target = "http://c"
+	}
+
+	p.PinKey(key, target)
+	if peer, ok := p.PickPeer(key); !ok || peer != p.httpGetters[target] {
+		t.Errorf("PickPeer with pin = %v, %v; want getter for %s", peer, ok, target)
+	}
+	p.PinKey(key, "http://a")
+	if _, ok := p.PickPeer(key); ok {
+		t.Error("PickPeer with pin to self picked a remote peer")
+	}
+	p.PinKey(key, "http://unknown")
+	p2, ok2 := p.PickPeer(key)
+	p.UnpinKey(key)
+	p1, ok1 := p.PickPeer(key)
+	if p1 != p2 || ok1 != ok2 {
+		t.Error("pin to unknown peer did not fall back to the consistent hash")
+	}
+}
+
+func TestHTTPGetterSendsPeer(t *testing.T) {
+	body, err := proto.Marshal(&pb.GetResponse{Value: []byte("v")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestingPeer(r)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	h := &httpGetter{self: "http://requester:8000", baseURL: ts.URL + "/"}
+	req := &pb.GetRequest{Group: proto.String("group"), Key: proto.String("key")}
+	if err := h.Get(nil, req, &pb.GetResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if got != "http://requester:8000" {
+		t.Errorf("RequestingPeer = %q; want %q", got, "http://requester:8000")
+	}
+}
+
+type ctxKey string
+
+func TestHTTPPoolTransportContext(t *testing.T) {
+	body, err := proto.Marshal(&pb.GetResponse{Value: []byte("v")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	var got Context
+	p := newHTTPPool("http://self", nil)
+	p.Transport = func(ctx Context) http.RoundTripper {
+		got = ctx
+		return http.DefaultTransport
+	}
+	p.Set(ts.URL)
+	g := newGroup("TestHTTPPoolTransportContext-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected local load")
+	}), p)
+
+	want := ctxKey("request-scoped")
+	var s string
+	if err := g.Get(want, "key", StringSink(&s)); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Transport got context %v; want %v", got, want)
+	}
+}
+
+func TestHTTPPoolPickPeers(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	p.Set("http://a", "http://b", "http://c")
+	for _, key := range testKeys(20) {
+		owners := p.peers.GetN(key, 3)
+		peers, ok := p.PickPeers(key, 3)
+		if owners[0] == "http://a" {
+			if ok {
+				t.Errorf("PickPeers(%q) ok when self is the owner", key)
+			}
+			continue
+		}
+		if !ok || len(peers) != 2 {
+			t.Fatalf("PickPeers(%q) = %d peers, %v; want 2, true", key, len(peers), ok)
+		}
+		if primary, _ := p.PickPeer(key); peers[0] != primary {
+			t.Errorf("PickPeers(%q)[0] is not the PickPeer result", key)
+		}
+	}
+}
+
+func TestHTTPPoolSetReplicas(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c"}
+	p := newHTTPPool("http://a", nil)
+	p.Set(peers...)
+	p.SetReplicas(200)
+	want := consistenthash.New(200, nil)
+	want.Add(peers...)
+	for _, key := range testKeys(50) {
+		owner := want.Get(key)
+		peer, ok := p.PickPeer(key)
+		if owner == "http://a" {
+			if ok {
+				t.Errorf("PickPeer(%q) ok; want self", key)
+			}
+			continue
+		}
+		if !ok || peer != p.httpGetters[owner] {
+			t.Errorf("PickPeer(%q) did not pick %s", key, owner)
+		}
+	}
+}
+
+func TestHTTPPoolSetReplicasRebalances(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	p.Set("http://a")
+	var calls int
+	p.OnRebalance = func(added, removed []string) {
+		calls++
+		if len(added) != 0 || len(removed) != 0 {
+			t.Errorf("OnRebalance(%v, %v); want no membership change", added, removed)
+		}
+	}
+	g := newGroup("TestHTTPPoolSetReplicasRebalances-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), p)
+	g.EvictOnRebalance = true
+	keys := testKeys(50)
+	for _, key := range keys {
+		var s string
+		if err := g.Get(nil, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Add a peer without rebalancing, as if it joined under another
+	// replica count, then let SetReplicas reshuffle.
+	p.mu.Lock()
+	p.setLocked("http://a", "http://b")
+	p.mu.Unlock()
+	p.SetReplicas(100)
+	if calls != 1 {
+		t.Errorf("OnRebalance calls = %d; want 1", calls)
+	}
+	for _, key := range keys {
+		_, isSelf := p.Owner(key)
+		if _, cached := g.GetFromCache(key, MainCache); cached != isSelf {
+			t.Errorf("key %q cached = %v, owned = %v after SetReplicas", key, cached, isSelf)
+		}
+	}
+	p.SetReplicas(100)
+	if calls != 1 {
+		t.Errorf("SetReplicas with an unchanged count called OnRebalance")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("SetReplicas(-1) did not panic")
+		}
+	}()
+	p.SetReplicas(-1)
+}
+
+func TestHTTPPoolRing(t *testing.T) {
+	p := newHTTPPool("http://a", &HTTPPoolOptions{Replicas: 2})
+	p.Set("http://a", "http://b")
+	ring := p.Ring()
+	if len(ring) != 4 {
+		t.Fatalf("Ring has %d entries; want 4", len(ring))
+	}
+	nodes := make(map[string]int)
+	for i, e := range ring {
+		if i > 0 && e.Hash < ring[i-1].Hash {
+			t.Errorf("Ring is not sorted at %d: %v", i, ring)
+		}
+		nodes[e.Node]++
+	}
+	if nodes["http://a"] != 2 || nodes["http://b"] != 2 {
+		t.Errorf("Ring nodes = %v; want 2 of each peer", nodes)
+	}
+}
+
+func TestHTTPPoolPeerPreference(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	p.PeerPreference = func(candidates []string) string {
+		for _, c := range candidates {
+			if c == "http://c" {
+				return c
+			}
+		}
+		return "http://unknown"
+	}
+	p.Set("http://a", "http://b", "http://c", "http://d")
+	for _, key := range testKeys(100) {
+		candidates := p.peers.GetN(key, 2)
+		var want ProtoGetter
+		wantOK := true
+		switch {
+		case candidates[0] == "http://a" || candidates[1] == "http://a":
+			want, wantOK = nil, false
+		case candidates[1] == "http://c":
+			want = p.httpGetters["http://c"]
+		default:
+			want = p.httpGetters[candidates[0]]
+		}
+		if got, ok := p.PickPeer(key); got != want || ok != wantOK {
+			t.Errorf("PickPeer(%q) with candidates %v = %v, %v; want %v, %v", key, candidates, got, ok, want, wantOK)
+		}
+	}
+}
+
+func TestHTTPPoolIncludeSelf(t *testing.T) {
+	want := newHTTPPool("http://a", nil)
+	want.Set("http://a", "http://b", "http://c")
+	p := newHTTPPool("http://a", &HTTPPoolOptions{IncludeSelf: true})
+	p.Set("http://b", "http://c")
+	local := 0
+	for _, key := range testKeys(100) {
+		wantPeer, wantOK := want.PickPeer(key)
+		peer, ok := p.PickPeer(key)
+		if ok != wantOK {
+			t.Errorf("PickPeer(%q) ok = %v; want %v", key, ok, wantOK)
+		} else if ok && peer.(*httpGetter).baseURL != wantPeer.(*httpGetter).baseURL {
+			t.Errorf("PickPeer(%q) = %s; want %s", key, peer.(*httpGetter).baseURL, wantPeer.(*httpGetter).baseURL)
+		}
+		if !ok {
+			local++
+		}
+	}
+	if local == 0 {
+		t.Error("no key was owned by the omitted self")
+	}
+
+	p.SetReplicas(10)
+	want.SetReplicas(10)
+	if got, want := fmt.Sprint(p.Ring()), fmt.Sprint(want.Ring()); got != want {
+		t.Errorf("ring after SetReplicas = %s; want %s", got, want)
+	}
+}
+
+func TestHTTPPoolStaticHash(t *testing.T) {
+	p := newHTTPPool("http://a", &HTTPPoolOptions{
+		Replicas: 1,
+		HashFn:   consistenthash.StaticHash(map[string]uint32{"0http://a": 10, "0http://b": 20, "local": 5, "remote": 15}),
+	})
+	p.Set("http://a", "http://b")
+	if _, ok := p.PickPeer("local"); ok {
+		t.Error("PickPeer(local) picked a remote peer")
+	}
+	if peer, ok := p.PickPeer("remote"); !ok || peer.(*httpGetter).baseURL != "http://b"+defaultBasePath {
+		t.Errorf("PickPeer(remote) = %v, %v; want http://b", peer, ok)
+	}
+}
+
+func TestHTTPPoolSetE(t *testing.T) {
+	p := newHTTPPool("http://a/", nil)
+	if err := p.SetE("http://a/", "https://b:8000"); err != nil {
+		t.Fatal(err)
+	}
+	if p.self != "http://a" {
+		t.Errorf("self = %q; want %q", p.self, "http://a")
+	}
+	if _, ok := p.httpGetters["http://a"]; !ok {
+		t.Errorf("peers %v do not include the normalized self", p.httpGetters)
+	}
+	for _, bad := range []string{"example.net:8000", "ftp://b", "http://", "http://b?x=1", "http://%zz"} {
+		if err := p.SetE("http://a", bad); err == nil {
+			t.Errorf("SetE accepted %q", bad)
+		}
+	}
+	if len(p.httpGetters) != 2 {
+		t.Errorf("a failed SetE changed the peers to %v", p.httpGetters)
+	}
+}
+
+func TestHTTPPoolSetTrailingSlash(t *testing.T) {
+	p := newHTTPPool("http://a:8080/", nil)
+	p.Set("http://a:8080/")
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if peer, ok := p.PickPeer(key); ok {
+			t.Fatalf("PickPeer(%q) = %v; want self", key, peer)
+		}
+	}
+	p.Set("http://a:8080/", "http://b:8080/")
+	p.PinKey("k", "http://b:8080/")
+	if peer, isSelf := p.Owner("k"); isSelf || peer != "http://b:8080" {
+		t.Errorf("Owner of pinned key = %q, %v; want %q, false", peer, isSelf, "http://b:8080")
+	}
+}
+
+func TestEvictOnRebalance(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	p.Set("http://a")
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	})
+	evicting := newGroup("TestEvictOnRebalance-evicting", 1<<20, getter, p)
+	evicting.EvictOnRebalance = true
+	keeping := newGroup("TestEvictOnRebalance-keeping", 1<<20, getter, p)
+	keys := testKeys(50)
+	for _, g := range []*Group{evicting, keeping} {
+		for _, key := range keys {
+			var s string
+			if err := g.Get(nil, key, StringSink(&s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	p.Set("http://a", "http://b")
+	for _, key := range keys {
+		_, remote := p.PickPeer(key)
+		if _, cached := evicting.GetFromCache(key, MainCache); cached == remote {
+			t.Errorf("key %q: cached = %v with remote owner = %v", key, cached, remote)
+		}
+	}
+	if n := keeping.CacheStats(MainCache).Items; n != int64(len(keys)) {
+		t.Errorf("group without EvictOnRebalance has %d items; want %d", n, len(keys))
+	}
+}
+
+func TestReclassifyHotOnRebalance(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	p.Set("http://a", "http://b")
+	g := newGroup("TestReclassifyHotOnRebalance-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), p)
+	g.ReclassifyHotOnRebalance = true
+	keys := testKeys(50)
+	for _, key := range keys {
+		g.populateCache(key, ByteView{s: "hot:" + key}, &g.hotCache)
+	}
+
+	p.Set("http://a", "http://c")
+	owned := 0
+	for _, key := range keys {
+		if _, ok := g.GetFromCache(key, HotCache); ok {
+			t.Errorf("key %q is still in the hotCache", key)
+		}
+		v, inMain := g.GetFromCache(key, MainCache)
+		if _, remote := p.PickPeer(key); remote == inMain {
+			t.Errorf("key %q: in mainCache = %v with remote owner = %v", key, inMain, remote)
+		}
+		if inMain {
+			owned++
+			if v.String() != "hot:"+key {
+				t.Errorf("moved value of %q = %q; want %q", key, v.String(), "hot:"+key)
+			}
+		}
+	}
+	if owned == 0 || owned == len(keys) {
+		t.Errorf("%d of %d keys owned locally; want some but not all", owned, len(keys))
+	}
+}
+
+func TestServeHTTPMaxKeyBytes(t *testing.T) {
+	g := newGroup("TestServeHTTPMaxKeyBytes-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), NoPeers{})
+	g.MaxKeyBytes = 4
+	p := newHTTPPool("http://self", nil)
+
+	for _, tt := range []struct {
+		key  string
+		code int
+	}{
+		{"abcd", http.StatusOK},
+		{"abcde", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", defaultBasePath+g.Name()+"/"+tt.key, nil))
+		if rec.Code != tt.code {
+			t.Errorf("ServeHTTP for key %q = %d; want %d", tt.key, rec.Code, tt.code)
+		}
+	}
+	var s string
+	if err := g.Get(nil, "abcde", StringSink(&s)); err != ErrKeyTooLong {
+		t.Errorf("Get with long key = %v; want ErrKeyTooLong", err)
+	}
+}
+
+func TestServeHTTPEscapedKeys(t *testing.T) {
+	g := newGroup("TestServeHTTPEscapedKeys/group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("got:" + key)
+	}), NoPeers{})
+	p := newHTTPPool("http://self", nil)
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+	getter := &httpGetter{baseURL: ts.URL + defaultBasePath}
+
+	for _, key := range []string{"a/b", "/leading", "with space", "100%", "%2F", "a+b", "\xff\x00"} {
+		req := &pb.GetRequest{Group: proto.String(g.Name()), Key: proto.String(key)}
+		res := &pb.GetResponse{}
+		if err := getter.Get(nil, req, res); err != nil {
+			t.Errorf("Get(%q): %v", key, err)
+			continue
+		}
+		if got, want := string(res.GetValue()), "got:"+key; got != want {
+			t.Errorf("Get(%q) = %q; want %q", key, got, want)
+		}
+	}
+}
+
+func TestHTTPGetterStatusErrors(t *testing.T) {
+	g := newGroup("TestHTTPGetterStatusErrors-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), NoPeers{})
+	g.MaxKeyBytes = 4
+	ts := httptest.NewServer(newHTTPPool("http://self", nil))
+	defer ts.Close()
+	getter := &httpGetter{baseURL: ts.URL + defaultBasePath}
+
+	for _, tt := range []struct {
+		group, key string
+		want       error
+	}{
+		{"TestHTTPGetterStatusErrors-missing", "k", ErrNoSuchGroup},
+		{g.Name(), "too-long", ErrBadPeerRequest},
+	} {
+		req := &pb.GetRequest{Group: proto.String(tt.group), Key: proto.String(tt.key)}
+		err := getter.Get(nil, req, &pb.GetResponse{})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Get(%q, %q) = %v; want %v", tt.group, tt.key, err, tt.want)
+			continue
+		}
+		var se *PeerStatusError
+		if !errors.As(err, &se) || se.Body == "" {
+			t.Errorf("Get(%q, %q) error %#v lacks status body", tt.group, tt.key, err)
+		}
+	}
+}
+
+func TestServeHTTPGeneration(t *testing.T) {
+	g := newGroup("TestServeHTTPGeneration-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), NoPeers{})
+	g.GenerationFunc = func(key string) uint64 { return uint64(len(key)) }
+	p := newHTTPPool("http://self", nil)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", defaultBasePath+g.Name()+"/abc", nil))
+	var res pb.GetResponse
+	if err := proto.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Generation == nil || res.GetGeneration() != 3 {
+		t.Errorf("response generation = %v; want 3", res.Generation)
+	}
+}
+
+func TestServeHTTPHead(t *testing.T) {
+	g := newGroup("TestServeHTTPHead-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		if key == "broken" {
+			return errors.New("backend down")
+		}
+		return dest.SetString("value-" + key)
+	}), NoPeers{})
+	p := newHTTPPool("http://self", nil)
+	base := defaultBasePath + g.Name() + "/"
+
+	tests := []struct {
+		path   string
+		code   int
+		length string
+	}{
+		{"cached?cacheonly", http.StatusNotFound, ""},
+		{"cached", http.StatusOK, "12"},
+		{"cached?cacheonly", http.StatusOK, "12"},
+		{"broken?cacheonly", http.StatusNotFound, ""},
+		{"broken", http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("HEAD", base+tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("HEAD %s: status = %d; want %d", tt.path, rec.Code, tt.code)
+		}
+		if got := rec.Header().Get("Content-Length"); got != tt.length {
+			t.Errorf("HEAD %s: Content-Length = %q; want %q", tt.path, got, tt.length)
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("HEAD %s: wrote %d body bytes; want none", tt.path, rec.Body.Len())
+		}
+	}
+}
+
+// encodingTransport records the Content-Encoding of the responses
+// it returns.
+type encodingTransport struct {
+	encodings []string
+}
+
+func (t *encodingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		t.encodings = append(t.encodings, res.Header.Get("Content-Encoding"))
+	}
+	return res, err
+}
+
+func TestHTTPPoolCompression(t *testing.T) {
+	value := strings.Repeat("compressible ", 1000)
+	g := newGroup("TestHTTPPoolCompression-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(value)
+	}), NoPeers{})
+	ts := httptest.NewServer(newHTTPPool("http://self", &HTTPPoolOptions{EnableCompression: true}))
+	defer ts.Close()
+
+	tr := &encodingTransport{}
+	for _, compress := range []bool{true, false} {
+		getter := &httpGetter{
+			baseURL:   ts.URL + defaultBasePath,
+			transport: func(Context) http.RoundTripper { return tr },
+			compress:  compress,
+		}
+		req := &pb.GetRequest{Group: proto.String(g.Name()), Key: proto.String("key")}
+		res := &pb.GetResponse{}
+		if err := getter.Get(nil, req, res); err != nil {
+			t.Fatalf("compress=%v: %v", compress, err)
+		}
+		if string(res.GetValue()) != value {
+			t.Errorf("compress=%v: got a %d byte value; want %d bytes", compress, len(res.GetValue()), len(value))
+		}
+	}
+	if got, want := fmt.Sprint(tr.encodings), "[gzip ]"; got != want {
+		t.Errorf("response encodings = %q; want %q", got, want)
+	}
+}
+
+func TestHTTPGetterGetMulti(t *testing.T) {
+	g := newGroup("TestHTTPGetterGetMulti-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("got:" + key)
+	}), NoPeers{})
+	ts := httptest.NewServer(newHTTPPool("http://self", nil))
+	defer ts.Close()
+	var getter MultiGetter = &httpGetter{baseURL: ts.URL + defaultBasePath}
+
+	keys := []string{"a", "b/c", "with space", "a"}
+	res := &pb.GetMultiResponse{}
+	if err := getter.GetMulti(nil, g.Name(), keys, res); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if got, want := string(res.Values[i].GetValue()), "got:"+key; got != want {
+			t.Errorf("value %d = %q; want %q", i, got, want)
+		}
+	}
+	if err := getter.GetMulti(nil, "TestHTTPGetterGetMulti-missing", keys, res); !errors.Is(err, ErrNoSuchGroup) {
+		t.Errorf("GetMulti of missing group = %v; want ErrNoSuchGroup", err)
+	}
+}
+
+func TestHTTPGetterPut(t *testing.T) {
+	g := newGroup("TestHTTPGetterPut-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected load")
+	}), NoPeers{})
+	g.AcceptPushes = true
+	ts := httptest.NewServer(newHTTPPool("http://self", nil))
+	defer ts.Close()
+	var pusher Pusher = &httpGetter{baseURL: ts.URL + defaultBasePath}
+
+	if err := pusher.Put(nil, g.Name(), "a/b", []byte("pushed")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := g.GetFromCache("a/b", MainCache); !ok || v.String() != "pushed" {
+		t.Errorf("main cache has %q, %v after Put; want %q", v.String(), ok, "pushed")
+	}
+	if err := pusher.Put(nil, "TestHTTPGetterPut-missing", "a", nil); !errors.Is(err, ErrNoSuchGroup) {
+		t.Errorf("Put to missing group = %v; want ErrNoSuchGroup", err)
+	}
+}
+
+func TestServePutRejects(t *testing.T) {
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return errors.New("unexpected load")
+	})
+	closed := newGroup("TestServePutRejects-closed", 1<<20, getter, NoPeers{})
+	open := newGroup("TestServePutRejects-open", 8, getter, NoPeers{})
+	open.AcceptPushes = true
+	open.MaxKeyBytes = 4
+	pool := newHTTPPool("http://self", nil)
+	ts := httptest.NewServer(pool)
+	defer ts.Close()
+	pusher := &httpGetter{baseURL: ts.URL + defaultBasePath}
+
+	put := func(g *Group, key, value string) int {
+		err := pusher.Put(nil, g.Name(), key, []byte(value))
+		var se *PeerStatusError
+		if !errors.As(err, &se) {
+			t.Fatalf("Put(%q, %q) = %v; want a *PeerStatusError", key, value, err)
+		}
+		if _, ok := g.GetFromCache(key, MainCache); ok {
+			t.Errorf("rejected Put(%q, %q) was cached", key, value)
+		}
+		return se.StatusCode
+	}
+	if code := put(closed, "a", "v"); code != http.StatusForbidden {
+		t.Errorf("Put to group without AcceptPushes = %d; want %d", code, http.StatusForbidden)
+	}
+	if code := put(open, "a", "123456789"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Put of value over cacheBytes = %d; want %d", code, http.StatusRequestEntityTooLarge)
+	}
+	if code := put(open, "abcde", "v"); code != http.StatusBadRequest {
+		t.Errorf("Put of over-long key = %d; want %d", code, http.StatusBadRequest)
+	}
+	pool.Set("http://self", "http://other")
+	pool.PinKey("a", "http://other")
+	if code := put(open, "a", "v"); code != http.StatusMisdirectedRequest {
+		t.Errorf("Put of key owned by another peer = %d; want %d", code, http.StatusMisdirectedRequest)
+	}
+}
+
+func TestHTTPGetterDecodeError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0xff, 0xff})
+	}))
+	defer ts.Close()
+	getter := &httpGetter{baseURL: ts.URL + defaultBasePath}
+	err := getter.Get(nil, &pb.GetRequest{Group: proto.String("group"), Key: proto.String("key")}, &pb.GetResponse{})
+	var decodeErr *PeerDecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("Get of a malformed body = %v; want a *PeerDecodeError", err)
+	}
+	if want := ts.URL + defaultBasePath + "group/key"; decodeErr.URL != want {
+		t.Errorf("URL = %q; want %q", decodeErr.URL, want)
+	}
+	if !strings.Contains(err.Error(), "ff ff ff") {
+		t.Errorf("error %q does not dump the body", err)
+	}
+}
+
+func TestContentHandler(t *testing.T) {
+	g := newGroup("TestContentHandler-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("0123456789")
+	}), NoPeers{})
+	h := ContentHandler(g, "/files/")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/files/digits.txt", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Errorf("GET = %d %q; want 200 %q", rec.Code, rec.Body.String(), "0123456789")
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q; want text/plain", ct)
+	}
+	lastModified := rec.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Error("no Last-Modified header")
+	}
+
+	req := httptest.NewRequest("GET", "/files/digits.txt", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "234" {
+		t.Errorf("Range GET = %d %q; want 206 %q", rec.Code, rec.Body.String(), "234")
+	}
+
+	req = httptest.NewRequest("GET", "/files/digits.txt", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("conditional GET = %d; want 304", rec.Code)
+	}
+}
+
+type traceKey struct{}
+
+func TestHTTPPoolPropagator(t *testing.T) {
+	g := newGroup("TestHTTPPoolPropagator-group", 1<<20, GetterFunc(func(ctx Context, key string, dest Sink) error {
+		trace, _ := ctx.(context.Context).Value(traceKey{}).(string)
+		return dest.SetString("trace=" + trace)
+	}), NoPeers{})
+	server := newHTTPPool("http://server", nil)
+	server.Extractor = func(ctx context.Context, h http.Header) context.Context {
+		return context.WithValue(ctx, traceKey{}, h.Get("X-Trace"))
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	client := newHTTPPool("http://client", nil)
+	client.Propagator = func(ctx context.Context, h http.Header) {
+		h.Set("X-Trace", ctx.Value(traceKey{}).(string))
+	}
+	client.Set(ts.URL)
+	peer, ok := client.PickPeer("key")
+	if !ok {
+		t.Fatal("client picked no peer")
+	}
+	ctx := context.WithValue(context.Background(), traceKey{}, "abc")
+	res := &pb.GetResponse{}
+	if err := peer.Get(ctx, &pb.GetRequest{Group: proto.String(g.Name()), Key: proto.String("key")}, res); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(res.GetValue()), "trace=abc"; got != want {
+		t.Errorf("value = %q; want %q", got, want)
+	}
+}
+
+func TestServeHTTPReportsKeyRate(t *testing.T) {
+	g := newGroup("TestServeHTTPReportsKeyRate-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), NoPeers{})
+	p := newHTTPPool("http://self", nil)
+	get := func() *pb.GetResponse {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", defaultBasePath+g.Name()+"/key", nil))
+		res := &pb.GetResponse{}
+		if err := proto.Unmarshal(rec.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if res := get(); res.MinuteQps != nil {
+		t.Errorf("rate reported without SetHotKeyQPS: %v", res.GetMinuteQps())
+	}
+	g.SetHotKeyQPS(1)
+	if res := get(); res.MinuteQps == nil {
+		t.Error("no rate reported with SetHotKeyQPS")
+	}
+}
+
+func TestHTTPPoolOnRebalance(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	var events []string
+	p.OnRebalance = func(added, removed []string) {
+		events = append(events, fmt.Sprintf("+%v -%v", added, removed))
+	}
+	p.Set("http://a", "http://b")
+	p.Set("http://a", "http://b")
+	p.Set("http://a", "http://c")
+	want := "[+[http://a http://b] -[] +[http://c] -[http://b]]"
+	if got := fmt.Sprint(events); got != want {
+		t.Errorf("OnRebalance events = %s; want %s", got, want)
+	}
+}
+
+func TestRemoveUnowned(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	g := newGroup("TestRemoveUnowned-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), p)
+	keys := testKeys(50)
+	for _, key := range keys {
+		var s string
+		if err := g.Get(nil, key, StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.OnRebalance = func(added, removed []string) { g.RemoveUnowned() }
+	p.Set("http://a", "http://b")
+
+	var owned int64
+	for _, key := range keys {
+		if _, remote := p.PickPeer(key); !remote {
+			owned++
+		}
+	}
+	if items := g.CacheStats(MainCache).Items; items != owned {
+		t.Errorf("mainCache has %d items after rebalance; want %d", items, owned)
+	}
+}
+
+func TestHTTPPoolClose(t *testing.T) {
+	p := NewHTTPPoolOpts("http://a", nil)
+	p.Set("http://a", "http://b")
+	if got := getPeers("closeTest"); got != PeerPicker(p) {
+		t.Fatalf("getPeers = %v; want the registered pool", got)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := getPeers("closeTest").(NoPeers); !ok {
+		t.Errorf("getPeers after Close is not NoPeers")
+	}
+	for _, key := range testKeys(20) {
+		if _, ok := p.PickPeer(key); ok {
+			t.Fatalf("PickPeer(%q) found a peer after Close", key)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+
+	// A new pool can be registered once the old one is closed.
+	p2 := NewHTTPPoolOpts("http://a", nil)
+	defer p2.Close()
+	if got := getPeers("closeTest"); got != PeerPicker(p2) {
+		t.Errorf("getPeers = %v; want the new pool", got)
+	}
+}
+
+func TestHTTPPoolNamespaceKeys(t *testing.T) {
+	p := newHTTPPool("http://a", nil)
+	p.NamespaceKeys = true
+	p.Set("http://a", "http://b", "http://c")
+	getter := GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(key)
+	})
+	g1 := newGroup("namespaceKeys1", 1<<20, getter, p)
+	g2 := newGroup("namespaceKeys2", 1<<20, getter, p)
+
+	differ := false
+	for _, key := range testKeys(50) {
+		o1, _ := g1.Owner(key)
+		o2, _ := g2.Owner(key)
+		if want, _ := p.Owner("namespaceKeys1/" + key); o1 != want {
+			t.Fatalf("Owner(%q) in group 1 = %q; want %q", key, o1, want)
+		}
+		if want, _ := p.Owner("namespaceKeys2/" + key); o2 != want {
+			t.Fatalf("Owner(%q) in group 2 = %q; want %q", key, o2, want)
+		}
+		differ = differ || o1 != o2
+	}
+	if !differ {
+		t.Error("every key had the same owner in both groups")
+	}
+
+	p.NamespaceKeys = false
+	got, _ := g1.Owner("1")
+	if want, _ := p.Owner("1"); got != want {
+		t.Errorf("Owner without NamespaceKeys = %q; want %q", got, want)
+	}
+}
+
+func TestServeHTTPResponseHints(t *testing.T) {
+	g := newGroup("TestServeHTTPResponseHints-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), NoPeers{})
+	p := newHTTPPool("http://self", nil)
+	get := func() *pb.GetResponse {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", defaultBasePath+g.Name()+"/key", nil))
+		res := &pb.GetResponse{}
+		if err := proto.Unmarshal(rec.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if res := get(); res.TtlMs != nil || res.Hot != nil {
+		t.Errorf("hints without SetTTL or SetHotKeyQPS: ttl %v, hot %v", res.GetTtlMs(), res.GetHot())
+	}
+	g.SetTTL(time.Minute)
+	g.SetHotKeyQPS(1e9)
+	res := get()
+	if ms := res.GetTtlMs(); ms <= 0 || ms > 60000 {
+		t.Errorf("ttl = %dms; want up to a minute", ms)
+	}
+	if res.Hot == nil || res.GetHot() {
+		t.Errorf("hot = %v; want false below the hot rate", res.Hot)
+	}
+}
+
+func TestHTTPGetterChunked(t *testing.T) {
+	const value = "0123456789abcdefghij"
+	g := newGroup("TestHTTPGetterChunked-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString(value)
+	}), NoPeers{})
+	g.GenerationFunc = func(string) uint64 { return 7 }
+	pool := newHTTPPool("http://self", &HTTPPoolOptions{EnableCompression: true})
+	ts := httptest.NewServer(pool)
+	defer ts.Close()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", defaultBasePath+g.Name()+"/key", nil)
+	req.Header.Set(chunkHeader, "8")
+	pool.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != chunkedContentType {
+		t.Errorf("Content-Type = %q; want %q", ct, chunkedContentType)
+	}
+
+	in := &pb.GetRequest{Group: proto.String(g.Name()), Key: proto.String("key")}
+	for _, compress := range []bool{false, true} {
+		getter := &httpGetter{baseURL: ts.URL + defaultBasePath, maxChunkBytes: 8, compress: compress}
+		out := &pb.GetResponse{}
+		if err := getter.Get(nil, in, out); err != nil {
+			t.Fatal(err)
+		}
+		if string(out.Value) != value || out.GetGeneration() != 7 {
+			t.Errorf("compress=%v: Get = %q, generation %d; want %q, 7", compress, out.Value, out.GetGeneration(), value)
+		}
+	}
+
+	getter := &httpGetter{baseURL: ts.URL + defaultBasePath, maxChunkBytes: 8, maxResponseBytes: 10}
+	if err := getter.Get(nil, in, &pb.GetResponse{}); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Get of an oversized chunked value = %v; want a size error", err)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	g := newGroup("TestStatsHandler-group", 1<<20, GetterFunc(func(_ Context, key string, dest Sink) error {
+		return dest.SetString("v")
+	}), NoPeers{})
+	var s string
+	for i := 0; i < 3; i++ {
+		if err := g.Get(dummyCtx, "key", StringSink(&s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := StatsHandler(g)
+	serve := func(target, accept string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/stats", "")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("default Content-Type = %q; want application/json", ct)
+	}
+	var report StatsReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Groups) != 1 || report.Groups[0].Name != g.Name() || report.Groups[0].Gets != 3 || report.Groups[0].MainCache.Items != 1 {
+		t.Errorf("report = %+v; want one group with 3 gets and 1 item", report)
+	}
+	if !strings.Contains(rec.Body.String(), `"cache_hits":2`) {
+		t.Errorf("JSON %s lacks the cache_hits field", rec.Body.String())
+	}
+
+	prom := "application/openmetrics-text;q=0.9,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+	for _, rec := range []*httptest.ResponseRecorder{serve("/stats", prom), serve("/stats?format=prometheus", "text/html")} {
+		want := `groupcache_gets_total{group="TestStatsHandler-group"} 3`
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("Prometheus output lacks %q:\n%s", want, rec.Body.String())
+		}
+	}
+
+	rec = serve("/stats", "text/html,application/xhtml+xml,*/*;q=0.8")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") || !strings.Contains(rec.Body.String(), "<td>TestStatsHandler-group</td>") {
+		t.Errorf("HTML response = %q, %q", ct, rec.Body.String())
+	}
+
+	if rec := serve("/stats?format=xml", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown format status = %d; want 400", rec.Code)
+	}
+}
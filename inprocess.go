@@ -0,0 +1,91 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"sync"
+
+	"groupcache/consistenthash"
+	pb "groupcache/groupcachepb"
+)
+
+// InProcessPool implements PeerPicker for a set of Groups living in
+// the current process, each standing in for one peer. Requests are
+// dispatched directly to the owning Group instead of going over HTTP,
+// which makes multi-peer behavior easy to exercise in tests.
+//
+// Each simulated peer needs its own InProcessPool, created with that
+// peer's name as self, and its own Group (group names are global, so
+// they must differ between peers). The pool is typically handed to
+// the Group with RegisterPerGroupPeerPicker.
+type InProcessPool struct {
+	self string
+
+	mu      sync.Mutex // guards peers and getters
+	peers   *consistenthash.Map
+	getters map[string]*inProcessGetter
+}
+
+// NewInProcessPool returns an InProcessPool for the peer named self.
+func NewInProcessPool(self string) *InProcessPool {
+	return &InProcessPool{
+		self:  self,
+		peers: consistenthash.New(defaultReplicas, nil),
+	}
+}
+
+// Set updates the pool's members, keyed by peer name. The Group for
+// self may be included; keys it owns are never dispatched.
+func (p *InProcessPool) Set(members map[string]*Group) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.getters = make(map[string]*inProcessGetter, len(members))
+	for name, g := range members {
+		p.peers.Add(name)
+		p.getters[name] = &inProcessGetter{g: g}
+	}
+}
+
+// PickPeer implements PeerPicker.
+func (p *InProcessPool) PickPeer(key string) (ProtoGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers.IsEmpty() {
+		return nil, false
+	}
+	if peer := p.peers.Get(key); peer != p.self {
+		return p.getters[peer], true
+	}
+	return nil, false
+}
+
+// inProcessGetter implements ProtoGetter by calling Get on a Group,
+// as ServeHTTP would on the remote peer.
+type inProcessGetter struct {
+	g *Group
+}
+
+func (h *inProcessGetter) Get(ctx Context, in *pb.GetRequest, out *pb.GetResponse) error {
+	h.g.Stats.ServerRequests.Add(1)
+	var value ByteView
+	if err := h.g.Get(ctx, in.GetKey(), ByteViewSink(&value)); err != nil {
+		return err
+	}
+	*out = *h.g.peerResponse(in.GetKey(), value)
+	return nil
+}
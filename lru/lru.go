@@ -20,7 +20,10 @@ package lru
 //所谓LRU其实就是操作系统里那个内存页管理的经典算法——最近最少被使用（Least Recently Used Algorithm）。
 // 其实除了操作系统底层，很多数据库或者缓存产品里都实现了LRU，例如Innodb存储引擎的buffer pool里的LRU List就是一个关键数据结构。
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
 //cache结构，数据存放在一个双向链表中，并提供一个map映射到key跟列表的元素，链表主要提供lru算法。map主要提供快速查找key
 // Cache is an LRU cache. It is not safe for concurrent access.
 type Cache struct { // LRU的高层封装（非并发安全！）
@@ -31,6 +34,24 @@ type Cache struct { // LRU的高层封装（非并发安全！）
 	// OnEvicted optionally specifies a callback function to be
 	// executed when an entry is purged from the cache.
 	OnEvicted func(key Key, value interface{})  // 数据项被淘汰时，回调函数，当一个entry被移除后回调
+
+	// OnEvictedReason optionally specifies a callback function to be
+	// executed when an entry is purged from the cache, along with the
+	// reason it was purged. It is called in addition to OnEvicted.
+	OnEvictedReason func(key Key, value interface{}, reason EvictReason)
+
+	// TrackIdle makes the cache record when each entry was last
+	// added or gotten, for RemoveIdle. If it is set after entries
+	// were added, those count as used at the first RemoveIdle.
+	TrackIdle bool
+
+	// Now optionally specifies the clock TrackIdle uses. If nil,
+	// time.Now is used.
+	Now func() time.Time
+
+	// idleStarted records that entries added before TrackIdle was
+	// set have been given a use time.
+	idleStarted bool
 	//下面用了一个map来做查找，用ll来做lru刷新
 	ll    *list.List //LRU双向链表。维护数据的访问次序.这个是标准库。
 	cache map[interface{}]*list.Element //Element是标准库中代表双链表的元素// 记录Key -> entry的映射关系（Element中的value存的是entry,），O(1)时间得到entry。所有我们需要根据key拿到的值就存在这个里面。
@@ -39,9 +60,47 @@ type Cache struct { // LRU的高层封装（非并发安全！）
 // A Key may be any value that is comparable. See http://golang.org/ref/spec#Comparison_operators
 type Key interface{} //Key是任意可比较（Comparable）类型
 
+// An EvictReason describes why an entry was purged from the cache.
+type EvictReason int
+
+const (
+	// ReasonCapacity means the entry was the oldest one and was
+	// removed to make room, either by Add or by RemoveOldest.
+	ReasonCapacity EvictReason = iota + 1
+
+	// ReasonManual means the entry was removed by Remove.
+	ReasonManual
+
+	// ReasonClear means the entry was removed by Clear.
+	ReasonClear
+
+	// ReasonExpired means the entry was removed by Expire.
+	ReasonExpired
+
+	// ReasonIdle means the entry was removed by RemoveIdle.
+	ReasonIdle
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonManual:
+		return "manual"
+	case ReasonClear:
+		return "clear"
+	case ReasonExpired:
+		return "expired"
+	case ReasonIdle:
+		return "idle"
+	}
+	return "unknown"
+}
+
 type entry struct { // 一个 entry 包含一个 key 和一个 value，都是任意类型
 	key   Key
 	value interface{}
+	used  int64 // UnixNano of the last Add or Get, if TrackIdle is set
 }
 
 // New creates a new Cache.
@@ -54,6 +113,18 @@ func New(maxEntries int) *Cache {
 		cache:      make(map[interface{}]*list.Element),
 	}
 }
+
+// NewWithCapacity is like New, but preallocates room for initialCap
+// entries, which avoids repeatedly growing the cache's index as a
+// large cache fills up.
+func NewWithCapacity(maxEntries, initialCap int) *Cache {
+	return &Cache{
+		MaxEntries: maxEntries,
+		ll:         list.New(),
+		cache:      make(map[interface{}]*list.Element, initialCap),
+	}
+}
+
 // Add方法，插入一个K-V对
 func (c *Cache) Add(key Key, value interface{}) {
 	if c.cache == nil { //若事先没有根据maxEntries来New一个Cache,那么此处就初始化一个大小没有限制的Cache（即MaxEntries为0的情况）
@@ -63,10 +134,12 @@ func (c *Cache) Add(key Key, value interface{}) {
 	if ee, ok := c.cache[key]; ok { // 如果该key已存在，更新entry里的value值，并将entry挪到链表头部
 		c.ll.MoveToFront(ee) //把这个节点移到头部
 		ee.Value.(*entry).value = value //修改这个节点的值
+		c.touch(ee)
 		return
 	}
-	ele := c.ll.PushFront(&entry{key, value}) // 如果该key不存在，新建一个entry，插到链表头部，插入的数据结构为entry，存到element,然后放到链表前面
+	ele := c.ll.PushFront(&entry{key: key, value: value}) // 如果该key不存在，新建一个entry，插到链表头部，插入的数据结构为entry，存到element,然后放到链表前面
 	c.cache[key] = ele
+	c.touch(ele)
 	if c.MaxEntries != 0 && c.ll.Len() > c.MaxEntries { // 如果超出链表允许长度，移除链表尾部的数据
 		c.RemoveOldest()
 	}
@@ -79,6 +152,18 @@ func (c *Cache) Get(key Key) (value interface{}, ok bool) {// Get方法，通过
 	}
 	if ele, hit := c.cache[key]; hit { //如果该key存在，获取对应entry的value，将该entry挪到链表头部，返回。
 		c.ll.MoveToFront(ele)
+		c.touch(ele)
+		return ele.Value.(*entry).value, true
+	}
+	return
+}
+
+// Peek returns the value for key without updating its recency.
+func (c *Cache) Peek(key Key) (value interface{}, ok bool) {
+	if c.cache == nil {
+		return
+	}
+	if ele, hit := c.cache[key]; hit {
 		return ele.Value.(*entry).value, true
 	}
 	return
@@ -90,10 +175,80 @@ func (c *Cache) Remove(key Key) {
 		return
 	}
 	if ele, hit := c.cache[key]; hit {
-		c.removeElement(ele)
+		c.removeElement(ele, ReasonManual)
 	}
 }
 
+// RemoveMulti removes the provided keys from the cache in one pass
+// and returns how many of them were present. Absent keys are
+// ignored, and a nil cache removes nothing.
+func (c *Cache) RemoveMulti(keys []Key) int {
+	if c == nil || c.cache == nil {
+		return 0
+	}
+	n := 0
+	for _, key := range keys {
+		if ele, hit := c.cache[key]; hit {
+			c.removeElement(ele, ReasonManual)
+			n++
+		}
+	}
+	return n
+}
+
+// Expire removes the provided key from the cache, reporting
+// ReasonExpired to OnEvictedReason. It is meant for callers that
+// track entry lifetimes themselves.
+func (c *Cache) Expire(key Key) {
+	if c.cache == nil {
+		return
+	}
+	if ele, hit := c.cache[key]; hit {
+		c.removeElement(ele, ReasonExpired)
+	}
+}
+
+// touch records that the entry of e was just used, if TrackIdle is set.
+func (c *Cache) touch(e *list.Element) {
+	if c.TrackIdle {
+		e.Value.(*entry).used = c.now().UnixNano()
+	}
+}
+
+func (c *Cache) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// RemoveIdle removes the entries that were not added or gotten since
+// cutoff, reporting ReasonIdle to OnEvictedReason, and returns how
+// many it removed. Entries are visited from least to most recently
+// used, so only the removed ones and one more are looked at. It
+// removes nothing unless TrackIdle is set.
+func (c *Cache) RemoveIdle(cutoff time.Time) int {
+	if c == nil || c.cache == nil || !c.TrackIdle {
+		return 0
+	}
+	if !c.idleStarted {
+		c.idleStarted = true
+		now := c.now().UnixNano()
+		for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+			if e := ele.Value.(*entry); e.used == 0 {
+				e.used = now
+			}
+		}
+	}
+	limit := cutoff.UnixNano()
+	n := 0
+	for ele := c.ll.Back(); ele != nil && ele.Value.(*entry).used < limit; ele = c.ll.Back() {
+		c.removeElement(ele, ReasonIdle)
+		n++
+	}
+	return n
+}
+
 // RemoveOldest removes the oldest item from the cache.
 func (c *Cache) RemoveOldest() {  //删除最老的这个元素
 	if c.cache == nil {
@@ -101,17 +256,24 @@ func (c *Cache) RemoveOldest() {  //删除最老的这个元素
 	}
 	ele := c.ll.Back()
 	if ele != nil {
-		c.removeElement(ele)
+		c.removeElement(ele, ReasonCapacity)
 	}
 }
 
-func (c *Cache) removeElement(e *list.Element) {
+func (c *Cache) removeElement(e *list.Element, reason EvictReason) {
 	c.ll.Remove(e)
 	kv := e.Value.(*entry)
 	delete(c.cache, kv.key)
+	c.evicted(kv, reason)
+}
+
+func (c *Cache) evicted(kv *entry, reason EvictReason) {
 	if c.OnEvicted != nil {
 		c.OnEvicted(kv.key, kv.value)
 	}
+	if c.OnEvictedReason != nil {
+		c.OnEvictedReason(kv.key, kv.value, reason)
+	}
 }
 
 // Len returns the number of items in the cache.
@@ -122,12 +284,62 @@ func (c *Cache) Len() int {
 	return c.ll.Len()
 }
 
+// Keys returns the keys in the cache, from most to least recently used.
+func (c *Cache) Keys() []Key {
+	if c.cache == nil {
+		return nil
+	}
+	keys := make([]Key, 0, c.ll.Len())
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.(*entry).key)
+	}
+	return keys
+}
+
+// Values returns the values in the cache, from most to least
+// recently used.
+func (c *Cache) Values() []interface{} {
+	if c.cache == nil {
+		return nil
+	}
+	values := make([]interface{}, 0, c.ll.Len())
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		values = append(values, e.Value.(*entry).value)
+	}
+	return values
+}
+
+// EstimateBytes returns the sum of sizeOf over the values in the
+// cache. The cache does not track sizes itself; sizeOf says how many
+// bytes a value accounts for.
+func (c *Cache) EstimateBytes(sizeOf func(value interface{}) int64) int64 {
+	var n int64
+	for _, v := range c.Values() {
+		n += sizeOf(v)
+	}
+	return n
+}
+
+// Each calls fn for each entry in the cache, from most to least
+// recently used, until fn returns false. It does not update recency.
+// fn must not modify the cache.
+func (c *Cache) Each(fn func(key Key, value interface{}) bool) {
+	if c.cache == nil {
+		return
+	}
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		kv := e.Value.(*entry)
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
 // Clear purges all stored items from the cache.
 func (c *Cache) Clear() {
-	if c.OnEvicted != nil {
+	if c.OnEvicted != nil || c.OnEvictedReason != nil {
 		for _, e := range c.cache {
-			kv := e.Value.(*entry)
-			c.OnEvicted(kv.key, kv.value)
+			c.evicted(e.Value.(*entry), ReasonClear)
 		}
 	}
 	c.ll = nil
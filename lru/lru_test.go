@@ -18,7 +18,9 @@ package lru
 
 import (
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 )
 
 type simpleStruct struct {
@@ -95,3 +97,204 @@ func TestEvict(t *testing.T) {
 		t.Fatalf("got %v in second evicted key; want %s", evictedKeys[1], "myKey1")
 	}
 }
+
+func TestEvictReason(t *testing.T) {
+	var evicted, reasons []string
+	lru := New(2)
+	lru.OnEvicted = func(key Key, value interface{}) {
+		evicted = append(evicted, key.(string))
+	}
+	lru.OnEvictedReason = func(key Key, value interface{}, reason EvictReason) {
+		reasons = append(reasons, key.(string)+":"+reason.String())
+	}
+	lru.Add("a", 1)
+	lru.Add("b", 2)
+	lru.Add("c", 3)
+	lru.Remove("b")
+	lru.Expire("c")
+	lru.Add("d", 4)
+	lru.Clear()
+
+	want := []string{"a:capacity", "b:manual", "c:expired", "d:clear"}
+	if fmt.Sprint(reasons) != fmt.Sprint(want) {
+		t.Fatalf("got reasons %v; want %v", reasons, want)
+	}
+	if len(evicted) != len(want) {
+		t.Fatalf("OnEvicted called %d times; want %d", len(evicted), len(want))
+	}
+}
+
+func TestSyncCache(t *testing.T) {
+	c := NewSyncCache(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("key%d", (i*100+j)%150)
+				c.Add(key, j)
+				c.Get(key)
+				if j%10 == 0 {
+					c.Remove(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := c.Len(); n > 100 {
+		t.Fatalf("Len = %d; want at most 100", n)
+	}
+	c.Clear()
+	if n := c.Len(); n != 0 {
+		t.Fatalf("Len after Clear = %d; want 0", n)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	lru := New(0)
+	if keys := lru.Keys(); len(keys) != 0 {
+		t.Fatalf("Keys of empty cache = %v; want none", keys)
+	}
+	lru.Add("a", 1)
+	lru.Add("b", 2)
+	lru.Add("c", 3)
+	lru.Get("a")
+	if got, want := fmt.Sprint(lru.Keys()), "[a c b]"; got != want {
+		t.Fatalf("Keys = %s; want %s", got, want)
+	}
+}
+
+func TestEach(t *testing.T) {
+	lru := New(0)
+	lru.Each(func(Key, interface{}) bool {
+		t.Fatal("Each visited an entry of an empty cache")
+		return false
+	})
+	lru.Add("a", 1)
+	lru.Add("b", 2)
+	lru.Add("c", 3)
+	var visited []string
+	lru.Each(func(key Key, value interface{}) bool {
+		visited = append(visited, fmt.Sprintf("%v=%v", key, value))
+		return len(visited) < 2
+	})
+	if got, want := fmt.Sprint(visited), "[c=3 b=2]"; got != want {
+		t.Errorf("Each visited %s; want %s", got, want)
+	}
+}
+
+func TestNewWithCapacity(t *testing.T) {
+	lru := NewWithCapacity(2, 100)
+	lru.Add("a", 1)
+	lru.Add("b", 2)
+	lru.Add("c", 3)
+	if got, want := fmt.Sprint(lru.Keys()), "[c b]"; got != want {
+		t.Errorf("Keys = %s; want %s", got, want)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	lru := New(2)
+	lru.Add("a", 1)
+	lru.Add("b", 2)
+	if v, ok := lru.Peek("a"); !ok || v != 1 {
+		t.Fatalf("Peek(a) = %v, %v; want 1, true", v, ok)
+	}
+	lru.Add("c", 3)
+	if _, ok := lru.Get("a"); ok {
+		t.Error("Peek updated the recency of a")
+	}
+}
+
+func TestValues(t *testing.T) {
+	lru := New(0)
+	if got := lru.Values(); len(got) != 0 {
+		t.Errorf("Values of an empty cache = %v; want none", got)
+	}
+	lru.Add("a", "x")
+	lru.Add("b", "yy")
+	lru.Add("c", "zzz")
+	lru.Get("a")
+	if got, want := fmt.Sprint(lru.Values()), "[x zzz yy]"; got != want {
+		t.Errorf("Values = %s; want %s", got, want)
+	}
+	size := func(v interface{}) int64 { return int64(len(v.(string))) }
+	if got := lru.EstimateBytes(size); got != 6 {
+		t.Errorf("EstimateBytes = %d; want 6", got)
+	}
+}
+
+func TestRemoveMulti(t *testing.T) {
+	var nilCache *Cache
+	if n := nilCache.RemoveMulti([]Key{"a"}); n != 0 {
+		t.Errorf("RemoveMulti on a nil cache = %d; want 0", n)
+	}
+	var evicted []Key
+	lru := New(0)
+	lru.OnEvicted = func(key Key, value interface{}) {
+		evicted = append(evicted, key)
+	}
+	lru.Add("a", 1)
+	lru.Add("b", 2)
+	lru.Add("c", 3)
+	if n := lru.RemoveMulti([]Key{"a", "missing", "c"}); n != 2 {
+		t.Errorf("RemoveMulti = %d; want 2", n)
+	}
+	if got, want := fmt.Sprint(evicted), "[a c]"; got != want {
+		t.Errorf("evicted %s; want %s", got, want)
+	}
+	if got, want := fmt.Sprint(lru.Keys()), "[b]"; got != want {
+		t.Errorf("Keys = %s; want %s", got, want)
+	}
+}
+
+func TestRemoveIdle(t *testing.T) {
+	var reasons []EvictReason
+	c := New(0)
+	c.TrackIdle = true
+	c.OnEvictedReason = func(key Key, value interface{}, reason EvictReason) {
+		reasons = append(reasons, reason)
+	}
+	c.Add("old", 1)
+	c.Add("used", 2)
+	time.Sleep(5 * time.Millisecond)
+	cutoff := time.Now()
+	c.Get("used")
+	c.Add("new", 3)
+
+	if n := c.RemoveIdle(cutoff); n != 1 {
+		t.Errorf("RemoveIdle = %d; want 1", n)
+	}
+	if _, ok := c.Peek("old"); ok {
+		t.Error("idle entry was kept")
+	}
+	if c.Len() != 2 || len(reasons) != 1 || reasons[0] != ReasonIdle {
+		t.Errorf("after RemoveIdle: %d entries, reasons %v; want 2 and [idle]", c.Len(), reasons)
+	}
+
+	untracked := New(0)
+	untracked.Add("key", 1)
+	if n := untracked.RemoveIdle(time.Now().Add(time.Hour)); n != 0 {
+		t.Errorf("RemoveIdle without TrackIdle = %d; want 0", n)
+	}
+}
+
+func TestRemoveIdleTrackedLate(t *testing.T) {
+	now := time.Unix(1e9, 0)
+	c := New(0)
+	c.Now = func() time.Time { return now }
+	c.Add("early", 1)
+	c.TrackIdle = true
+	if n := c.RemoveIdle(now.Add(-time.Minute)); n != 0 {
+		t.Errorf("first RemoveIdle after setting TrackIdle = %d; want 0", n)
+	}
+	now = now.Add(time.Hour)
+	c.Add("late", 2)
+	if n := c.RemoveIdle(now.Add(-time.Minute)); n != 1 {
+		t.Errorf("RemoveIdle = %d; want 1", n)
+	}
+	if _, ok := c.Peek("early"); ok {
+		t.Error("entry idle since TrackIdle was set was kept")
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2013 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lru
+
+import "sync"
+
+// SyncCache is an LRU cache that is safe for concurrent access.
+// It wraps a Cache with a mutex and exposes the same API.
+type SyncCache struct {
+	mu sync.RWMutex
+	c  *Cache
+}
+
+// NewSyncCache creates a new SyncCache.
+// If maxEntries is zero, the cache has no limit and it's assumed
+// that eviction is done by the caller.
+func NewSyncCache(maxEntries int) *SyncCache {
+	return &SyncCache{c: New(maxEntries)}
+}
+
+// Add adds a value to the cache.
+func (s *SyncCache) Add(key Key, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c.Add(key, value)
+}
+
+// Get looks up a key's value from the cache.
+func (s *SyncCache) Get(key Key) (value interface{}, ok bool) {
+	// Get updates recency, so it needs the write lock.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.Get(key)
+}
+
+// Remove removes the provided key from the cache.
+func (s *SyncCache) Remove(key Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c.Remove(key)
+}
+
+// RemoveOldest removes the oldest item from the cache.
+func (s *SyncCache) RemoveOldest() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c.RemoveOldest()
+}
+
+// Len returns the number of items in the cache.
+func (s *SyncCache) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.c.Len()
+}
+
+// Clear purges all stored items from the cache.
+func (s *SyncCache) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c.Clear()
+}
@@ -20,18 +20,32 @@ package groupcache
 
 import (
 	pb "groupcache/groupcachepb"
+	"sync"
 )
 
 // Context is an opaque value passed through calls to the
 // ProtoGetter. It may be nil if your ProtoGetter implementation does
-// not require a context.
+// not require a context. The Context given to Group.Get is passed
+// unchanged to the Getter and to ProtoGetter.Get.
 type Context interface{}
 
 // ProtoGetter is the interface that must be implemented by a peer.
+// The Group reuses in and out, so Get must not retain them after it
+// returns; it may keep out.Value.
 type ProtoGetter interface {
 	Get(context Context, in *pb.GetRequest, out *pb.GetResponse) error
 }
 
+// A MultiGetter is a ProtoGetter that can also fetch the values of
+// several keys of a group in a single request, for instance to warm
+// a cache in bulk. The HTTP peers returned by HTTPPool implement it.
+type MultiGetter interface {
+	ProtoGetter
+
+	// GetMulti fills out with one value per key, in order.
+	GetMulti(context Context, group string, keys []string, out *pb.GetMultiResponse) error
+}
+
 // PeerPicker is the interface that must be implemented to locate
 // the peer that owns a specific key.
 type PeerPicker interface {
@@ -41,6 +55,46 @@ type PeerPicker interface {
 	PickPeer(key string) (peer ProtoGetter, ok bool)
 }
 
+// A ReplicaPicker is a PeerPicker that can also nominate the peers
+// after a key's owner, in preference order, for read redundancy.
+type ReplicaPicker interface {
+	PeerPicker
+
+	// PickPeers returns the remote peers among the first n owners
+	// of key, in preference order. It returns nil, false if the
+	// current peer is the key's primary owner.
+	PickPeers(key string, n int) (peers []ProtoGetter, ok bool)
+}
+
+// A Pusher is a ProtoGetter that can also store a value in the
+// peer's cache. See Group.PushToOwnerOnLoad.
+type Pusher interface {
+	ProtoGetter
+
+	// Put stores value for key in the peer's cache for group.
+	Put(context Context, group, key string, value []byte) error
+}
+
+// An OwnerPicker is a PeerPicker that can also name the peer that
+// owns a key. See Group.Owner.
+type OwnerPicker interface {
+	PeerPicker
+
+	// Owner returns the name of the peer that owns key, such as
+	// its URL, and whether that peer is the current one.
+	Owner(key string) (peer string, isSelf bool)
+}
+
+// A GroupKeyer is a PeerPicker that places keys by the group they
+// belong to as well as by their name. A Group passes PeerKey(group,
+// key) rather than key to the PeerPicker's methods.
+type GroupKeyer interface {
+	PeerPicker
+
+	// PeerKey returns the key to locate the owner of key in group by.
+	PeerKey(group, key string) string
+}
+
 // NoPeers is an implementation of PeerPicker that never finds a peer.
 type NoPeers struct{}
 
@@ -55,6 +109,9 @@ func (NoPeers) PickPeer(key string) (peer ProtoGetter, ok bool) { return }
 //所以需要看一下PeerPicker和HTTPPool的关系，HTTPPool实现了PickPeer方法，所以HTTPPool是PeerPicker接口类型的。
 
 var (
+	// portPickerMu guards portPicker and httpPoolMade.
+	portPickerMu sync.Mutex
+
 	portPicker func(groupName string) PeerPicker //函数，根据group名拿到对等节点拾取器，其实拿到的就是NewHTTPPool创建的那个HTTPPool结构体
 )
 
@@ -63,6 +120,8 @@ var (
 // Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
 // called exactly once, but not both.
 func RegisterPeerPicker(fn func() PeerPicker) {
+	portPickerMu.Lock()
+	defer portPickerMu.Unlock()
 	if portPicker != nil { //这个变量是这个包中全局的，只被初始化一次
 		panic("RegisterPeerPicker called more than once")
 	}
@@ -76,6 +135,8 @@ func RegisterPeerPicker(fn func() PeerPicker) {
 // Either RegisterPeerPicker or RegisterPerGroupPeerPicker should be
 // called exactly once, but not both.
 func RegisterPerGroupPeerPicker(fn func(groupName string) PeerPicker) {
+	portPickerMu.Lock()
+	defer portPickerMu.Unlock()
 	if portPicker != nil {
 		panic("RegisterPeerPicker called more than once")
 	}
@@ -83,10 +144,13 @@ func RegisterPerGroupPeerPicker(fn func(groupName string) PeerPicker) {
 }
 
 func getPeers(groupName string) PeerPicker {
-	if portPicker == nil {
+	portPickerMu.Lock()
+	fn := portPicker
+	portPickerMu.Unlock()
+	if fn == nil {
 		return NoPeers{}
 	}
-	pk := portPicker(groupName) //根据group名拿到对等节点拾取器，但是在这个例子中，无论groupName是什么，拿到的都是前面base peer生成的HTTPPool
+	pk := fn(groupName) //根据group名拿到对等节点拾取器，但是在这个例子中，无论groupName是什么，拿到的都是前面base peer生成的HTTPPool
 	if pk == nil {
 		pk = NoPeers{}
 	}
@@ -0,0 +1,96 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLoadRateLimited is returned by Get when a local load is needed but
+// the group's load rate limit has been reached and the group is
+// configured not to wait. See Group.SetLoadRateLimit.
+var ErrLoadRateLimited = errors.New("groupcache: local load rate limit exceeded")
+
+// tokenBucket is a token bucket rate limiter. Its zero value imposes
+// no limit.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second; 0 means unlimited
+	block  bool    // wait for a token instead of failing
+	tokens float64
+	last   time.Time
+}
+
+func (tb *tokenBucket) set(perSec int, block bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.rate = float64(perSec)
+	tb.block = block
+	tb.tokens = tb.rate
+	tb.last = time.Time{} // set by the first take, on the group's clock
+}
+
+// take consumes a token at now, waiting for one to become available
+// if the bucket is configured to block. A wait ends early with
+// ctx.Err() if ctx is a context.Context that is done first.
+func (tb *tokenBucket) take(ctx Context, now time.Time) error {
+	tb.mu.Lock()
+	if tb.rate <= 0 {
+		tb.mu.Unlock()
+		return nil
+	}
+	if tb.last.IsZero() {
+		tb.last = now
+	}
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+	tb.last = now
+	if tb.tokens >= 1 {
+		tb.tokens--
+		tb.mu.Unlock()
+		return nil
+	}
+	if !tb.block {
+		tb.mu.Unlock()
+		return ErrLoadRateLimited
+	}
+	// Reserve the next token and wait until it has accrued.
+	wait := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+	tb.tokens--
+	tb.mu.Unlock()
+	var done <-chan struct{}
+	if c, ok := ctx.(context.Context); ok {
+		done = c.Done()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-done:
+		// Give the reserved token back.
+		tb.mu.Lock()
+		tb.tokens++
+		tb.mu.Unlock()
+		return ctx.(context.Context).Err()
+	}
+}
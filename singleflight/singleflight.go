@@ -18,13 +18,24 @@ limitations under the License.
 // mechanism.
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
 
 // call is an in-flight or completed Do call
 type call struct { // call等价于一条被真正执行的对某个key的查询操作
-	wg  sync.WaitGroup // 用于阻塞对某个key的多条查询命令，同一时刻只能有1条真正执行的查询命令
+	done chan struct{} // 用于阻塞对某个key的多条查询命令，同一时刻只能有1条真正执行的查询命令
 	val interface{} // 查询结果，也就是缓存中某个key对应的value值
 	err error
+
+	// waiters counts the callers waiting for a call started by
+	// DoShared, and cancel abandons it once none are left. Both are
+	// guarded by Group.mu.
+	waiters int
+	cancel  context.CancelFunc
 }
 
 // Group represents a class of work and forms a namespace in which
@@ -32,6 +43,45 @@ type call struct { // call等价于一条被真正执行的对某个key的查询
 type Group struct { // Group相当于一个管理每个key的call请求的对象
 	mu sync.Mutex       // 并发情况下，保证m这个普通map不会有并发安全问题
 	m  map[string]*call // key为数据的key(非hash的)，value为一条call命令，记录下某个key当前时刻有没有客户端在查询
+
+	// MaxInFlight, if positive, limits the number of keys with a call
+	// in flight. A call for a new key beyond the limit fails with
+	// ErrTooManyInFlight; callers for keys already in flight still
+	// wait as usual. It must be set before the Group is used.
+	MaxInFlight int
+}
+
+// ErrTooManyInFlight is returned by Do and its variants when a Group's
+// MaxInFlight limit is reached.
+var ErrTooManyInFlight = errors.New("singleflight: too many calls in flight")
+
+// ErrTimeout is returned by DoWithTimeout when the call does not
+// finish in time.
+var ErrTimeout = errors.New("singleflight: call timed out")
+
+// InFlight returns the number of keys with a call in flight, including
+// completed calls whose results DoWithTTL is still retaining.
+func (g *Group) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.m)
+}
+
+// Pending reports whether a call for key is in flight, that is,
+// started and not yet finished.
+func (g *Group) Pending(key string) bool {
+	g.mu.Lock()
+	c, ok := g.m[key]
+	g.mu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
 }
 
 // Do executes and returns the results of the given function, making
@@ -41,6 +91,15 @@ type Group struct { // Group相当于一个管理每个key的call请求的对象
 
 // 当客户端想查询某个key对应的值时会调用Do方法来执行查询。参数传入一个待查询的key，还有一个对应的查询方法，返回key对应的value值
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return g.DoWithTTL(key, fn, 0)
+}
+
+// DoWithTTL is like Do, but once fn has returned, its results are
+// retained for ttl and handed to any caller of Do or DoWithTTL for the
+// same key during that time, without running fn again. This
+// coalesces bursts of calls that are close together but do not
+// overlap. A ttl of zero or less retains nothing, just like Do.
+func (g *Group) DoWithTTL(key string, fn func() (interface{}, error), ttl time.Duration) (interface{}, error) {
 	g.mu.Lock() // 为了保证普通map的并发安全，要先上锁
 	if g.m == nil { // 检查map有无初始化
 		g.m = make(map[string]*call)
@@ -48,25 +107,181 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 	// 检查当前时刻，该key是否已经有别的客户端在查询
 	// 如果有别的客户端也正在查询，map里肯定存有该key，以及一条对应的call命令
 	if c, ok := g.m[key]; ok {
+		// Never released, so that a DoShared call is not abandoned
+		// while this caller waits for it.
+		c.waiters++
 		g.mu.Unlock() // 解锁，自己准备阻塞，此时已不存在并发安全问题，允许别人进行查询
-		c.wg.Wait() // 阻塞，等待别的客户端完成查询就好，不用自己再去耗费资源查询
+		<-c.done // 阻塞，等待别的客户端完成查询就好，不用自己再去耗费资源查询
 		return c.val, c.err  // 阻塞结束，说明别人已经查询完成，拿来主义直接返回
 	}
+	return g.run(key, fn, ttl)
+}
+
+// DoWithTimeout is like Do, but a caller waits at most d for the
+// call, then gives up with ErrTimeout. A caller that gives up also
+// forgets the call, so that the next caller for the key starts a new
+// one instead of waiting for a call that may never finish; fn keeps
+// running in the background and its results go to the callers still
+// waiting for it. A d of zero or less waits forever, just like Do.
+func (g *Group) DoWithTimeout(key string, fn func() (interface{}, error), d time.Duration) (interface{}, error) {
+	if d <= 0 {
+		return g.Do(key, fn)
+	}
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	c, ok := g.m[key]
+	if !ok {
+		if g.MaxInFlight > 0 && len(g.m) >= g.MaxInFlight {
+			g.mu.Unlock()
+			return nil, ErrTooManyInFlight
+		}
+		c = &call{done: make(chan struct{})}
+		g.m[key] = c
+		go func() {
+			c.val, c.err = fn()
+			close(c.done)
+			g.forget(key, c)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-timer.C:
+	}
+	g.leave(key, c, true)
+	return nil, ErrTimeout
+}
+
+// DoContext is like Do, but a caller that waits for a duplicate call
+// already in flight stops waiting when ctx is done, and returns
+// ctx.Err(). The in-flight call keeps running for the other callers.
+// The caller that runs fn is not interrupted; fn should honor ctx
+// itself.
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.waiters++
+		g.mu.Unlock()
+		return g.wait(ctx, key, c)
+	}
+	return g.run(key, fn, 0)
+}
+
+// DoShared is like DoContext, but fn runs in its own goroutine with a
+// context that is canceled once every caller waiting for it, the
+// first one included, has given up because its ctx is done. This
+// abandons work nobody wants anymore. The context passed to fn
+// carries the values of the first caller's ctx, but not its deadline
+// or cancelation. A call abandoned this way is forgotten at once, so
+// a later caller for the key starts a new one.
+func (g *Group) DoShared(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	c, ok := g.m[key]
+	if !ok {
+		if g.MaxInFlight > 0 && len(g.m) >= g.MaxInFlight {
+			g.mu.Unlock()
+			return nil, ErrTooManyInFlight
+		}
+		fctx, cancel := context.WithCancel(detachedContext{ctx})
+		c = &call{done: make(chan struct{}), cancel: cancel}
+		g.m[key] = c
+		go func() {
+			c.val, c.err = fn(fctx)
+			cancel()
+			close(c.done)
+			g.forget(key, c)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+	return g.wait(ctx, key, c)
+}
+
+// wait waits for c to finish, or for ctx to be done. A caller that
+// gives up is no longer counted as a waiter of c.
+func (g *Group) wait(ctx context.Context, key string, c *call) (interface{}, error) {
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+	}
+	g.leave(key, c, false)
+	return nil, ctx.Err()
+}
+
+// leave stops counting a caller that gave up on c as its waiter. The
+// call is forgotten if forget is set, or if it was started by
+// DoShared and nobody is left waiting for it, in which case it is
+// also canceled.
+func (g *Group) leave(key string, c *call, forget bool) {
+	g.mu.Lock()
+	c.waiters--
+	if c.waiters == 0 && c.cancel != nil {
+		c.cancel()
+		forget = true
+	}
+	if forget && g.m[key] == c {
+		delete(g.m, key)
+	}
+	g.mu.Unlock()
+}
+
+// detachedContext carries the values of its parent, but is never
+// done.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// run executes fn as a new call for key. g.mu must be held; run
+// releases it.
+func (g *Group) run(key string, fn func() (interface{}, error), ttl time.Duration) (interface{}, error) {
+	if g.MaxInFlight > 0 && len(g.m) >= g.MaxInFlight {
+		g.mu.Unlock()
+		return nil, ErrTooManyInFlight
+	}
 	// 如果能执行到此步，说明当前时刻没有别人在查询该key，当前客户端是
 	// 当前时刻第一个想要查询该key的人，就插入一条key -> call记录
 	// 注意，此时的map仍然是上锁状态，因为还要对map进行插入，有并发安全问题
-	c := new(call)
-	c.wg.Add(1) //支持只有一个goroutine去执行fn，因为在52行有个c.wg.Wait()
+	c := &call{done: make(chan struct{})} //支持只有一个goroutine去执行fn，其他的goroutine阻塞在<-c.done上
 	g.m[key] = c
 	g.mu.Unlock()
 	// 执行作为参数传入的查询方法
 	// **同一时刻对于同一个key只可能有一个客户端执行到此处**
 	c.val, c.err = fn() //获取数据
-	c.wg.Done()
+	close(c.done)
 
-	g.mu.Lock()
-	delete(g.m, key) // 执行完查询方法，把map中的key -> call删掉
-	g.mu.Unlock()
+	if ttl > 0 {
+		time.AfterFunc(ttl, func() { g.forget(key, c) })
+	} else {
+		g.forget(key, c) // 执行完查询方法，把map中的key -> call删掉
+	}
 
 	return c.val, c.err
 }
+
+// forget removes c from the map, unless it has been replaced.
+func (g *Group) forget(key string, c *call) {
+	g.mu.Lock()
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
+	g.mu.Unlock()
+}
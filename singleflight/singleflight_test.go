@@ -17,6 +17,7 @@ limitations under the License.
 package singleflight
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -83,3 +84,204 @@ func TestDoDupSuppress(t *testing.T) {
 		t.Errorf("number of calls = %d; want 1", got)
 	}
 }
+
+func TestDoWithTTL(t *testing.T) {
+	var g Group
+	var calls int32
+	fn := func() (interface{}, error) {
+		return atomic.AddInt32(&calls, 1), nil
+	}
+
+	v1, _ := g.DoWithTTL("key", fn, 50*time.Millisecond)
+	v2, _ := g.DoWithTTL("key", fn, 50*time.Millisecond)
+	if v1 != v2 || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("within ttl: got %v then %v after %d calls; want 1 call", v1, v2, calls)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if v, _ := g.DoWithTTL("key", fn, 0); v != int32(2) {
+		t.Errorf("after ttl: got %v; want 2", v)
+	}
+	if v, _ := g.Do("key", fn); v != int32(3) {
+		t.Errorf("Do without ttl: got %v; want 3", v)
+	}
+}
+
+func TestDoContext(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	started := make(chan struct{})
+	leader := make(chan interface{})
+	go func() {
+		v, _ := g.DoContext(context.Background(), "key", func() (interface{}, error) {
+			close(started)
+			<-release
+			return "bar", nil
+		})
+		leader <- v
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	v, err := g.DoContext(ctx, "key", func() (interface{}, error) {
+		t.Error("duplicate call ran fn")
+		return nil, nil
+	})
+	if err != context.DeadlineExceeded || v != nil {
+		t.Errorf("DoContext while in flight = %v, %v; want nil, %v", v, err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	if v := <-leader; v != "bar" {
+		t.Errorf("leader got %v; want %q", v, "bar")
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	g := Group{MaxInFlight: 1}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		g.Do("a", func() (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		close(done)
+	}()
+	<-started
+	if n := g.InFlight(); n != 1 {
+		t.Errorf("InFlight = %d; want 1", n)
+	}
+	if _, err := g.Do("b", func() (interface{}, error) {
+		t.Error("fn ran beyond MaxInFlight")
+		return nil, nil
+	}); err != ErrTooManyInFlight {
+		t.Errorf("Do beyond MaxInFlight = %v; want ErrTooManyInFlight", err)
+	}
+	close(release)
+	<-done
+	if n := g.InFlight(); n != 0 {
+		t.Errorf("InFlight after completion = %d; want 0", n)
+	}
+	if _, err := g.Do("b", func() (interface{}, error) { return nil, nil }); err != nil {
+		t.Errorf("Do after completion = %v", err)
+	}
+}
+
+func TestDoShared(t *testing.T) {
+	var g Group
+	type ctxKey struct{}
+	started := make(chan struct{})
+	abandoned := make(chan interface{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		abandoned <- ctx.Value(ctxKey{})
+		return nil, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "first"))
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err := g.DoShared(ctx1, "key", fn)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, err := g.DoShared(ctx2, "key", func(context.Context) (interface{}, error) {
+			t.Error("duplicate call ran fn")
+			return nil, nil
+		})
+		errs <- err
+	}()
+	for waiters := 0; waiters < 2; time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		waiters = g.m["key"].waiters
+		g.mu.Unlock()
+	}
+
+	cancel1()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("first caller got %v; want %v", err, context.Canceled)
+	}
+	select {
+	case <-abandoned:
+		t.Fatal("call abandoned while a caller still waits")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel2()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("second caller got %v; want %v", err, context.Canceled)
+	}
+	if v := <-abandoned; v != "first" {
+		t.Errorf("fn's context has value %v; want %q", v, "first")
+	}
+	if v, err := g.DoShared(context.Background(), "key", func(context.Context) (interface{}, error) {
+		return "new", nil
+	}); v != "new" || err != nil {
+		t.Errorf("DoShared after abandon = %v, %v; want %q, nil", v, err, "new")
+	}
+}
+
+func TestDoWithTimeout(t *testing.T) {
+	var g Group
+	stuck := make(chan struct{})
+	defer close(stuck)
+	var calls int32
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-stuck
+		return "late", nil
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := g.DoWithTimeout("key", fn, 100*time.Millisecond)
+			errs <- err
+		}()
+	}
+	for waiters := 0; waiters < 2; time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		if c, ok := g.m["key"]; ok {
+			waiters = c.waiters
+		}
+		g.mu.Unlock()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != ErrTimeout {
+			t.Errorf("DoWithTimeout = %v; want ErrTimeout", err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("fn ran %d times; want 1", n)
+	}
+	if n := g.InFlight(); n != 0 {
+		t.Errorf("InFlight after timeout = %d; want 0", n)
+	}
+	if v, err := g.DoWithTimeout("key", func() (interface{}, error) {
+		return "fresh", nil
+	}, time.Second); v != "fresh" || err != nil {
+		t.Errorf("DoWithTimeout after timeout = %v, %v; want %q, nil", v, err, "fresh")
+	}
+}
+
+func TestPending(t *testing.T) {
+	var g Group
+	if g.Pending("key") {
+		t.Error("Pending on an empty Group")
+	}
+	g.DoWithTTL("key", func() (interface{}, error) {
+		if !g.Pending("key") {
+			t.Error("Pending = false during the call")
+		}
+		return nil, nil
+	}, time.Minute)
+	if g.Pending("key") {
+		t.Error("Pending = true for a finished call retained by DoWithTTL")
+	}
+}
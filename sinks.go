@@ -17,7 +17,11 @@ limitations under the License.
 package groupcache
 
 import (
+	"bytes"
 	"errors"
+	"hash"
+	"io"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -39,6 +43,12 @@ type Sink interface { //SetXXX用来存储数据,view方法获取到一些东西
 	// The caller retains ownership of m.
 	SetProto(m proto.Message) error
 
+	// SetBytesWithExpiry is like SetBytes, but also tells the Group
+	// that the value must not be served from its cache after expiry.
+	// It lets one group hold values with different lifetimes; a
+	// group TTL, if set, still applies when it is sooner.
+	SetBytesWithExpiry(v []byte, expiry time.Time) error
+
 	// view returns a frozen view of the bytes for caching.
 	view() (ByteView, error)
 }
@@ -62,6 +72,9 @@ func setSinkView(s Sink, v ByteView) error {
 		return vs.setView(v) //一般是分开处理ByteView中的b或者s，这里明显是不需要区分了，直接设置整个ByteView
 	}
 	//如果不是，则通过Sink的SetXxx()方法设置ByteView
+	if !v.e.IsZero() {
+		return s.SetBytesWithExpiry(v.BytesNoCopy(), v.e)
+	}
 	if v.b != nil {
 		return s.SetBytes(v.b)
 	}
@@ -95,6 +108,12 @@ func (s *stringSink) SetBytes(v []byte) error {
 	return s.SetString(string(v))
 }
 
+func (s *stringSink) SetBytesWithExpiry(v []byte, expiry time.Time) error {
+	err := s.SetBytes(v)
+	s.v.e = expiry
+	return err
+}
+
 func (s *stringSink) SetProto(m proto.Message) error {
 	b, err := proto.Marshal(m) //编码
 	if err != nil {
@@ -149,6 +168,11 @@ func (s *byteViewSink) SetBytes(b []byte) error { //【复制b，初始化byteVi
 	return nil
 }
 
+func (s *byteViewSink) SetBytesWithExpiry(b []byte, expiry time.Time) error {
+	*s.dst = ByteView{b: cloneBytes(b), e: expiry}
+	return nil
+}
+
 func (s *byteViewSink) SetString(v string) error { //【通过使用string类型的v初始化一个ByteView后初始化byteViewSink的dst】
 	*s.dst = ByteView{s: v}
 	return nil
@@ -182,6 +206,14 @@ func (s *protoSink) SetBytes(b []byte) error { //【将s.dst反序列化后丢
 	return nil
 }
 
+func (s *protoSink) SetBytesWithExpiry(b []byte, expiry time.Time) error {
+	if err := s.SetBytes(b); err != nil {
+		return err
+	}
+	s.v.e = expiry
+	return nil
+}
+
 func (s *protoSink) SetString(v string) error { //【将b解码后写入s.dst】
 	b := []byte(v)
 	err := proto.Unmarshal(b, s.dst)
@@ -250,6 +282,14 @@ func (s *allocBytesSink) SetBytes(b []byte) error { //【复制一份b，然后
 	return s.setBytesOwned(cloneBytes(b))
 }
 
+func (s *allocBytesSink) SetBytesWithExpiry(b []byte, expiry time.Time) error {
+	if err := s.SetBytes(b); err != nil {
+		return err
+	}
+	s.v.e = expiry
+	return nil
+}
+
 func (s *allocBytesSink) setBytesOwned(b []byte) error { //【使用b设置allocBytesSink的dst和ByteView】
 	if s.dst == nil {
 		return errors.New("nil AllocatingByteSliceSink *[]byte dst")
@@ -271,6 +311,52 @@ func (s *allocBytesSink) SetString(v string) error {
 	return nil
 }
 
+// AppendingByteSliceSink returns a Sink that appends the value it
+// receives to *dst instead of replacing *dst, so that several Gets
+// can build up one buffer. The Sink's view of the value, which the
+// Group caches, is only the last value set, kept apart from *dst.
+func AppendingByteSliceSink(dst *[]byte) Sink {
+	return &appendBytesSink{dst: dst}
+}
+
+type appendBytesSink struct {
+	dst *[]byte
+	v   ByteView
+}
+
+func (s *appendBytesSink) view() (ByteView, error) {
+	return s.v, nil
+}
+
+func (s *appendBytesSink) setView(v ByteView) error {
+	if s.dst == nil {
+		return errors.New("nil AppendingByteSliceSink *[]byte dst")
+	}
+	*s.dst = v.AppendTo(*s.dst)
+	s.v = v
+	return nil
+}
+
+func (s *appendBytesSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.setView(ByteView{b: b})
+}
+
+func (s *appendBytesSink) SetBytes(b []byte) error {
+	return s.setView(ByteView{b: cloneBytes(b)})
+}
+
+func (s *appendBytesSink) SetBytesWithExpiry(b []byte, expiry time.Time) error {
+	return s.setView(ByteView{b: cloneBytes(b), e: expiry})
+}
+
+func (s *appendBytesSink) SetString(v string) error {
+	return s.setView(ByteView{s: v})
+}
+
 // TruncatingByteSliceSink returns a Sink that writes up to len(*dst)
 // bytes to *dst. If more bytes are available, they're silently
 // truncated. If fewer bytes are available than len(*dst), *dst
@@ -279,9 +365,18 @@ func TruncatingByteSliceSink(dst *[]byte) Sink { //【截面字节切片Sink，
 	return &truncBytesSink{dst: dst}
 }
 
+// TruncatingByteSliceSinkN is like TruncatingByteSliceSink, but also
+// sets *truncated to the number of bytes of the value that did not
+// fit in *dst, or zero if the whole value fit, so that callers can
+// detect an undersized buffer.
+func TruncatingByteSliceSinkN(dst *[]byte, truncated *int) Sink {
+	return &truncBytesSink{dst: dst, truncated: truncated}
+}
+
 type truncBytesSink struct {
-	dst *[]byte
-	v   ByteView
+	dst       *[]byte
+	truncated *int // optional, see TruncatingByteSliceSinkN
+	v         ByteView
 }
 
 func (s *truncBytesSink) view() (ByteView, error) {
@@ -300,6 +395,14 @@ func (s *truncBytesSink) SetBytes(b []byte) error {
 	return s.setBytesOwned(cloneBytes(b))
 }
 
+func (s *truncBytesSink) SetBytesWithExpiry(b []byte, expiry time.Time) error {
+	if err := s.SetBytes(b); err != nil {
+		return err
+	}
+	s.v.e = expiry
+	return nil
+}
+
 func (s *truncBytesSink) setBytesOwned(b []byte) error {
 	if s.dst == nil {
 		return errors.New("nil TruncatingByteSliceSink *[]byte dst")
@@ -308,11 +411,18 @@ func (s *truncBytesSink) setBytesOwned(b []byte) error {
 	if n < len(*s.dst) {
 		*s.dst = (*s.dst)[:n]
 	}
+	s.setTruncated(len(b) - n)
 	s.v.b = b
 	s.v.s = ""
 	return nil
 }
 
+func (s *truncBytesSink) setTruncated(n int) {
+	if s.truncated != nil {
+		*s.truncated = n
+	}
+}
+
 func (s *truncBytesSink) SetString(v string) error {
 	if s.dst == nil {
 		return errors.New("nil TruncatingByteSliceSink *[]byte dst")
@@ -321,7 +431,108 @@ func (s *truncBytesSink) SetString(v string) error {
 	if n < len(*s.dst) {
 		*s.dst = (*s.dst)[:n]
 	}
+	s.setTruncated(len(v) - n)
 	s.v.b = nil
 	s.v.s = v
 	return nil
 }
+
+// ErrChecksumMismatch is returned by a ChecksumSink whose value does
+// not hash to the expected checksum.
+var ErrChecksumMismatch = errors.New("groupcache: checksum mismatch")
+
+// ChecksumSink returns a Sink that hashes the value it receives with
+// a hash from h and, if the sum equals expected, passes the value on
+// to inner. Otherwise the Set method fails with ErrChecksumMismatch
+// and inner is not touched.
+func ChecksumSink(inner Sink, expected []byte, h func() hash.Hash) Sink {
+	return &checksumSink{inner: inner, expected: expected, h: h}
+}
+
+type checksumSink struct {
+	inner    Sink
+	expected []byte
+	h        func() hash.Hash
+}
+
+func (s *checksumSink) verify(write func(io.Writer)) error {
+	h := s.h()
+	write(h)
+	if !bytes.Equal(h.Sum(nil), s.expected) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func (s *checksumSink) view() (ByteView, error) {
+	return s.inner.view()
+}
+
+func (s *checksumSink) SetString(v string) error {
+	if err := s.verify(func(w io.Writer) { io.WriteString(w, v) }); err != nil {
+		return err
+	}
+	return s.inner.SetString(v)
+}
+
+func (s *checksumSink) SetBytes(v []byte) error {
+	if err := s.verify(func(w io.Writer) { w.Write(v) }); err != nil {
+		return err
+	}
+	return s.inner.SetBytes(v)
+}
+
+func (s *checksumSink) SetBytesWithExpiry(v []byte, expiry time.Time) error {
+	if err := s.verify(func(w io.Writer) { w.Write(v) }); err != nil {
+		return err
+	}
+	return s.inner.SetBytesWithExpiry(v, expiry)
+}
+
+func (s *checksumSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := s.verify(func(w io.Writer) { w.Write(b) }); err != nil {
+		return err
+	}
+	return s.inner.SetBytes(b)
+}
+
+// InspectingSink returns a Sink that calls onSet with the name of
+// each Set method invoked on it, such as "SetString", before passing
+// the call on to inner. It helps to debug how a Getter fills its
+// destination.
+func InspectingSink(inner Sink, onSet func(method string)) Sink {
+	return &inspectingSink{inner: inner, onSet: onSet}
+}
+
+type inspectingSink struct {
+	inner Sink
+	onSet func(method string)
+}
+
+func (s *inspectingSink) view() (ByteView, error) {
+	return s.inner.view()
+}
+
+func (s *inspectingSink) SetString(v string) error {
+	s.onSet("SetString")
+	return s.inner.SetString(v)
+}
+
+func (s *inspectingSink) SetBytes(v []byte) error {
+	s.onSet("SetBytes")
+	return s.inner.SetBytes(v)
+}
+
+func (s *inspectingSink) SetBytesWithExpiry(v []byte, expiry time.Time) error {
+	s.onSet("SetBytesWithExpiry")
+	return s.inner.SetBytesWithExpiry(v, expiry)
+}
+
+func (s *inspectingSink) SetProto(m proto.Message) error {
+	s.onSet("SetProto")
+	return s.inner.SetProto(m)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2012 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groupcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StatsReport is the JSON document served by StatsHandler. Its schema
+// is stable, so tools may depend on it:
+//
+//	{
+//	  "groups": [
+//	    {
+//	      "name": "thumbnails",
+//	      "gets": 10, "cache_hits": 7, "peer_loads": 1,
+//	      "peer_errors": 0, "loads": 3, "loads_deduped": 2,
+//	      "local_loads": 2, "local_load_errs": 0,
+//	      "server_requests": 4,
+//	      "main_cache": {"bytes": 512, "items": 2, "gets": 9,
+//	                     "hits": 6, "evictions": 0},
+//	      "hot_cache": {...}
+//	    }
+//	  ]
+//	}
+//
+// Groups are sorted by name. Counters only grow, except the bytes and
+// items of the caches.
+type StatsReport struct {
+	Groups []GroupStatsReport `json:"groups"`
+}
+
+// GroupStatsReport is the statistics of one group in a StatsReport.
+type GroupStatsReport struct {
+	Name string `json:"name"`
+	StatsSnapshot
+}
+
+// Formats served by StatsHandler.
+const (
+	statsJSON       = "json"
+	statsPrometheus = "prometheus"
+	statsHTML       = "html"
+)
+
+// statsMediaTypes maps the media types StatsHandler negotiates to the
+// format it serves for them.
+var statsMediaTypes = map[string]string{
+	"application/json":             statsJSON,
+	"text/plain":                   statsPrometheus,
+	"application/openmetrics-text": statsPrometheus,
+	"text/html":                    statsHTML,
+}
+
+// StatsHandler returns an http.Handler that serves the statistics of
+// groups, or of every group created so far if none are given. The
+// format is chosen by the "format" query parameter ("json",
+// "prometheus" or "html") or else by the Accept header, and defaults
+// to JSON. JSON follows the schema of StatsReport; Prometheus text is
+// in the exposition format, with one series per group and cache.
+func StatsHandler(groups ...*Group) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "":
+			format = negotiateStatsFormat(r.Header.Get("Accept"))
+		case statsJSON, statsPrometheus, statsHTML:
+		default:
+			http.Error(w, "unknown format: "+format, http.StatusBadRequest)
+			return
+		}
+		report := statsReport(groups)
+		switch format {
+		case statsPrometheus:
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			writePrometheusStats(w, report)
+		case statsHTML:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			statsTemplate.Execute(w, report)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+		}
+	})
+}
+
+// statsReport snapshots list, or all registered groups if list is
+// empty.
+func statsReport(list []*Group) StatsReport {
+	if len(list) == 0 {
+		mu.RLock()
+		for _, g := range groups {
+			list = append(list, g)
+		}
+		mu.RUnlock()
+	}
+	report := StatsReport{Groups: make([]GroupStatsReport, 0, len(list))}
+	for _, g := range list {
+		report.Groups = append(report.Groups, GroupStatsReport{Name: g.Name(), StatsSnapshot: g.Snapshot()})
+	}
+	sort.Slice(report.Groups, func(i, j int) bool { return report.Groups[i].Name < report.Groups[j].Name })
+	return report
+}
+
+// negotiateStatsFormat picks the format for an Accept header: the
+// supported media type with the highest quality, the earliest one on
+// a tie, or JSON if none is supported.
+func negotiateStatsFormat(accept string) string {
+	format, best := statsJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		f, ok := statsMediaTypes[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+		if q > best {
+			format, best = f, q
+		}
+	}
+	return format
+}
+
+// promLabel escapes a Prometheus label value.
+var promLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writePrometheusStats writes report in the Prometheus text
+// exposition format.
+func writePrometheusStats(w io.Writer, report StatsReport) {
+	groupMetrics := []struct {
+		name, help string
+		value      func(s StatsSnapshot) int64
+	}{
+		{"gets_total", "Get requests, including from peers.", func(s StatsSnapshot) int64 { return s.Gets }},
+		{"cache_hits_total", "Gets served from either cache.", func(s StatsSnapshot) int64 { return s.CacheHits }},
+		{"peer_loads_total", "Values loaded from peers.", func(s StatsSnapshot) int64 { return s.PeerLoads }},
+		{"peer_errors_total", "Failed loads from peers.", func(s StatsSnapshot) int64 { return s.PeerErrors }},
+		{"loads_total", "Gets that missed the cache.", func(s StatsSnapshot) int64 { return s.Loads }},
+		{"loads_deduped_total", "Loads left after deduplication.", func(s StatsSnapshot) int64 { return s.LoadsDeduped }},
+		{"local_loads_total", "Successful loads from the Getter.", func(s StatsSnapshot) int64 { return s.LocalLoads }},
+		{"local_load_errors_total", "Failed loads from the Getter.", func(s StatsSnapshot) int64 { return s.LocalLoadErrs }},
+		{"server_requests_total", "Gets received from peers.", func(s StatsSnapshot) int64 { return s.ServerRequests }},
+	}
+	for _, m := range groupMetrics {
+		fmt.Fprintf(w, "# HELP groupcache_%s %s\n# TYPE groupcache_%s counter\n", m.name, m.help, m.name)
+		for _, g := range report.Groups {
+			fmt.Fprintf(w, "groupcache_%s{group=\"%s\"} %d\n", m.name, promLabel.Replace(g.Name), m.value(g.StatsSnapshot))
+		}
+	}
+
+	cacheMetrics := []struct {
+		name, help, typ string
+		value           func(s CacheStats) int64
+	}{
+		{"cache_bytes", "Bytes held by the cache.", "gauge", func(s CacheStats) int64 { return s.Bytes }},
+		{"cache_items", "Items held by the cache.", "gauge", func(s CacheStats) int64 { return s.Items }},
+		{"cache_gets_total", "Lookups in the cache.", "counter", func(s CacheStats) int64 { return s.Gets }},
+		{"cache_lookup_hits_total", "Lookups that found a value.", "counter", func(s CacheStats) int64 { return s.Hits }},
+		{"cache_evictions_total", "Values evicted from the cache.", "counter", func(s CacheStats) int64 { return s.Evictions }},
+	}
+	for _, m := range cacheMetrics {
+		fmt.Fprintf(w, "# HELP groupcache_%s %s\n# TYPE groupcache_%s %s\n", m.name, m.help, m.name, m.typ)
+		for _, g := range report.Groups {
+			name := promLabel.Replace(g.Name)
+			fmt.Fprintf(w, "groupcache_%s{group=\"%s\",cache=\"main\"} %d\n", m.name, name, m.value(g.MainCache))
+			fmt.Fprintf(w, "groupcache_%s{group=\"%s\",cache=\"hot\"} %d\n", m.name, name, m.value(g.HotCache))
+		}
+	}
+}
+
+var statsTemplate = template.Must(template.New("stats").Parse(`<!DOCTYPE html>
+<html><head><title>groupcache stats</title></head><body>
+<table border="1">
+<tr><th>Group</th><th>Gets</th><th>Cache hits</th><th>Peer loads</th><th>Peer errors</th><th>Loads</th><th>Loads deduped</th><th>Local loads</th><th>Local load errors</th><th>Server requests</th><th>Main cache bytes</th><th>Main cache items</th><th>Hot cache bytes</th><th>Hot cache items</th></tr>
+{{range .Groups}}<tr><td>{{.Name}}</td><td>{{.Gets}}</td><td>{{.CacheHits}}</td><td>{{.PeerLoads}}</td><td>{{.PeerErrors}}</td><td>{{.Loads}}</td><td>{{.LoadsDeduped}}</td><td>{{.LocalLoads}}</td><td>{{.LocalLoadErrs}}</td><td>{{.ServerRequests}}</td><td>{{.MainCache.Bytes}}</td><td>{{.MainCache.Items}}</td><td>{{.HotCache.Bytes}}</td><td>{{.HotCache.Items}}</td></tr>
+{{end}}</table>
+</body></html>
+`))